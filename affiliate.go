@@ -0,0 +1,62 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+var errMissingCampaignID = errors.New("affiliate params require campid")
+
+// affiliateParamNames are the eBay Partner Network tracking params that may
+// be added to view item URLs.
+var affiliateParamNames = map[string]bool{
+	"campid":   true,
+	"customid": true,
+	"mkcid":    true,
+	"mkevt":    true,
+	"mkrid":    true,
+	"siteid":   true,
+	"toolid":   true,
+}
+
+// affiliateParams holds the tracking params added to stored view item URLs,
+// if any.
+var affiliateParams url.Values
+
+// setAffiliateParams parses s as the query string of eBay Partner Network
+// tracking params to add to view item URLs.
+func setAffiliateParams(s string) error {
+	v, err := url.ParseQuery(s)
+	if err != nil {
+		return fmt.Errorf("invalid affiliate params %q: %w", s, err)
+	}
+	for k := range v {
+		if !affiliateParamNames[k] {
+			return fmt.Errorf("unknown affiliate param %q", k)
+		}
+	}
+	if v.Get("campid") == "" {
+		return errMissingCampaignID
+	}
+	affiliateParams = v
+	return nil
+}
+
+// addAffiliateParams returns the view item URL u with the affiliate params
+// set, replacing any of the same name already in u.
+func addAffiliateParams(u string) (string, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	q := pu.Query()
+	for k, vs := range affiliateParams {
+		q[k] = vs
+	}
+	pu.RawQuery = q.Encode()
+	return pu.String(), nil
+}
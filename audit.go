@@ -0,0 +1,34 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/matthewdargan/ebay"
+)
+
+// findItemsResponses has a field for the response of each Finding API
+// operation, so that any response body decodes into it.
+type findItemsResponses struct {
+	Advanced []ebay.FindItemsResponse `json:"findItemsAdvancedResponse"`
+	Category []ebay.FindItemsResponse `json:"findItemsByCategoryResponse"`
+	Keywords []ebay.FindItemsResponse `json:"findItemsByKeywordsResponse"`
+	Product  []ebay.FindItemsResponse `json:"findItemsByProductResponse"`
+	Stores   []ebay.FindItemsResponse `json:"findItemsIneBayStoresResponse"`
+}
+
+// auditFields logs the first field in the response body b that the ebay
+// package does not model, so that eBay adding fields is noticed. Other
+// decoding errors are left to the ebay package.
+func auditFields(b []byte) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var r findItemsResponses
+	if err := dec.Decode(&r); err != nil && strings.HasPrefix(err.Error(), "json: unknown field") {
+		warnf("eBay response has an unmodeled field: %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import "testing"
+
+//nolint:paralleltest // Captures the log.
+func TestAuditFields(t *testing.T) {
+	tests := []struct {
+		body   string
+		logged string
+	}{
+		{`{"findItemsByKeywordsResponse":[{"ack":["Success"]}]}`, ""},
+		{
+			`{"findItemsByKeywordsResponse":[{"ack":["Success"],"newField":["x"]}]}`,
+			"eBay response has an unmodeled field: \"newField\"\n",
+		},
+		{`not json`, ""},
+	}
+	for _, tt := range tests {
+		buf := captureLog(t)
+		auditFields([]byte(tt.body))
+		if got := buf.String(); got != tt.logged {
+			t.Errorf("auditFields(%s) logged %q, want %q", tt.body, got, tt.logged)
+		}
+	}
+}
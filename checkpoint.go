@@ -0,0 +1,72 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+)
+
+// A checkpoint records the last page of a query whose items were stored, so
+// that an interrupted run of the query can resume after it.
+type checkpoint struct {
+	Query string `json:"query"`
+	Page  int    `json:"page"`
+}
+
+// querySignature identifies the request for the operation op with params,
+// whatever its page number.
+func querySignature(op string, params map[string]string) string {
+	q := make(url.Values, len(params))
+	for k, v := range params {
+		if k != "paginationInput.pageNumber" {
+			q.Set(k, v)
+		}
+	}
+	return op + "?" + q.Encode()
+}
+
+// resumePage returns the page after the last page stored for query, as
+// recorded in the checkpoint file name, or 0 if name does not exist or
+// records another query.
+func resumePage(name, query string) (int, error) {
+	b, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot read checkpoint: %w", err)
+	}
+	var cp checkpoint
+	if err = json.Unmarshal(b, &cp); err != nil {
+		return 0, fmt.Errorf("invalid checkpoint %s: %w", name, err)
+	}
+	if cp.Query != query {
+		return 0, nil
+	}
+	return cp.Page + 1, nil
+}
+
+// saveCheckpoint records in the file name that the pages of query before
+// next were stored. If next is 0, every page was stored and name is removed.
+func saveCheckpoint(name, query string, next int) error {
+	if next == 0 {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("cannot remove checkpoint: %w", err)
+		}
+		return nil
+	}
+	b, err := json.Marshal(checkpoint{Query: query, Page: next - 1})
+	if err != nil {
+		return fmt.Errorf("cannot encode checkpoint: %w", err)
+	}
+	if err = os.WriteFile(name, b, 0o644); err != nil {
+		return fmt.Errorf("cannot write checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+//nolint:paralleltest // Replaces sleep.
+func TestCheckpointResume(t *testing.T) {
+	stubSleep(t)
+	name := filepath.Join(t.TempDir(), "checkpoint")
+	params := map[string]string{"keywords": "checkpoint"}
+	query := querySignature("keyword", params)
+	if err := saveCheckpoint(name, query, 3); err != nil {
+		t.Fatal(err)
+	}
+	if page, err := resumePage(name, querySignature("keyword", map[string]string{"keywords": "other"})); err != nil || page != 0 {
+		t.Errorf("resumePage for another query = %d, %v, want 0, nil", page, err)
+	}
+	page, err := resumePage(name, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page != 3 {
+		t.Fatalf("resumePage = %d, want 3", page)
+	}
+	params["paginationInput.pageNumber"] = strconv.Itoa(page)
+	f, pages := pagedFinder(4)
+	_, next, err := findAll(context.Background(), f, operations["keyword"], params, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 4}; !slices.Equal(*pages, want) {
+		t.Errorf("fetched pages %v, want %v", *pages, want)
+	}
+	if err = saveCheckpoint(name, query, next); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("checkpoint not removed after the last page: %v", err)
+	}
+}
+
+//nolint:paralleltest // Replaces sleep.
+func TestCheckpointMaxPages(t *testing.T) {
+	stubSleep(t)
+	f, _ := pagedFinder(10)
+	_, next, err := findAll(context.Background(), f, operations["keyword"], map[string]string{"keywords": "max pages"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != 3 {
+		t.Errorf("next page = %d, want 3", next)
+	}
+	f, _ = pagedFinder(150)
+	rs, next, err := findAll(context.Background(), f, operations["keyword"], map[string]string{"keywords": "max pages", "paginationInput.pageNumber": "99"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 2 || next != 0 {
+		t.Errorf("findAll ending at page 100 = %d responses, next page %d, want 2, 0", len(rs), next)
+	}
+}
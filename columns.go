@@ -0,0 +1,80 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// columnNames maps item table column names to the names used in place of
+// them, for storing items in an existing table with other column names.
+var columnNames = make(map[string]string)
+
+// setColumnName records a column name mapping given as column=name, where
+// column is one of the item table columns.
+func setColumnName(s string) error {
+	col, name, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid column mapping %q", s)
+	}
+	if !isItemColumn(col) {
+		return fmt.Errorf("unknown column %q", col)
+	}
+	columnNames[col] = name
+	return nil
+}
+
+// checkColumnNames reports an error if the column name mappings would store
+// two columns under the same name.
+func checkColumnNames() error {
+	seen := make(map[string]string, len(itemColumns))
+	for _, c := range itemColumns {
+		name := columnName(c.name)
+		if other, ok := seen[name]; ok {
+			return fmt.Errorf("columns %s and %s both map to %s", other, c.name, name)
+		}
+		seen[name] = c.name
+	}
+	return nil
+}
+
+// columnName returns the name under which the item table column col is
+// stored.
+func columnName(col string) string {
+	return cmp.Or(columnNames[col], col)
+}
+
+// copyColumnNames returns the names of the columns items are copied into,
+// in the order of itemColumns.
+func copyColumnNames() []string {
+	names := make([]string, len(itemColumns))
+	for i, c := range itemColumns {
+		names[i] = columnName(c.name)
+	}
+	return names
+}
+
+// quoteColumns returns the column names quoted as SQL identifiers and
+// separated by commas, since mapped names may be reserved words or contain
+// any character.
+func quoteColumns(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = pq.QuoteIdentifier(n)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func isItemColumn(col string) bool {
+	for _, c := range itemColumns {
+		if c.name == col {
+			return true
+		}
+	}
+	return false
+}
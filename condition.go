@@ -0,0 +1,65 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errInvalidCondition = errors.New("invalid condition")
+
+// conditionIDs maps lowercased eBay condition names to condition IDs.
+var conditionIDs = map[string]string{
+	"new":                      "1000",
+	"new other":                "1500",
+	"new with defects":         "1750",
+	"certified refurbished":    "2000",
+	"excellent - refurbished":  "2010",
+	"very good - refurbished":  "2020",
+	"good - refurbished":       "2030",
+	"seller refurbished":       "2500",
+	"like new":                 "2750",
+	"used":                     "3000",
+	"very good":                "4000",
+	"good":                     "5000",
+	"acceptable":               "6000",
+	"for parts or not working": "7000",
+}
+
+// normalizeConditions replaces condition names in Condition item filters in
+// params with condition IDs, so that either may be given. The name
+// Unspecified and numeric IDs are passed through.
+func normalizeConditions(params map[string]string) error {
+	for k, v := range params {
+		if v != "Condition" || !isItemFilterName(k) {
+			continue
+		}
+		prefix := strings.TrimSuffix(k, "name") + "value"
+		for vk, vv := range params {
+			if !strings.HasPrefix(vk, prefix) {
+				continue
+			}
+			id, err := conditionID(vv)
+			if err != nil {
+				return err
+			}
+			params[vk] = id
+		}
+	}
+	return nil
+}
+
+// conditionID returns the condition ID for the condition name or ID s.
+func conditionID(s string) (string, error) {
+	if _, err := strconv.Atoi(s); err == nil || s == "Unspecified" {
+		return s, nil
+	}
+	if id, ok := conditionIDs[strings.ToLower(s)]; ok {
+		return id, nil
+	}
+	return "", fmt.Errorf("%w %q", errInvalidCondition, s)
+}
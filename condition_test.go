@@ -0,0 +1,52 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"maps"
+	"testing"
+)
+
+func TestNormalizeConditions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		params map[string]string
+		want   map[string]string
+		err    error
+	}{
+		{
+			map[string]string{"itemFilter.name": "Condition", "itemFilter.value": "Used"},
+			map[string]string{"itemFilter.name": "Condition", "itemFilter.value": "3000"},
+			nil,
+		},
+		{
+			map[string]string{"itemFilter(1).name": "Condition", "itemFilter(1).value(0)": "like new", "itemFilter(1).value(1)": "1000"},
+			map[string]string{"itemFilter(1).name": "Condition", "itemFilter(1).value(0)": "2750", "itemFilter(1).value(1)": "1000"},
+			nil,
+		},
+		{
+			map[string]string{"itemFilter.name": "Condition", "itemFilter.value": "Unspecified"},
+			map[string]string{"itemFilter.name": "Condition", "itemFilter.value": "Unspecified"},
+			nil,
+		},
+		{
+			map[string]string{"itemFilter.name": "MaxPrice", "itemFilter.value": "Used"},
+			map[string]string{"itemFilter.name": "MaxPrice", "itemFilter.value": "Used"},
+			nil,
+		},
+		{map[string]string{"itemFilter.name": "Condition", "itemFilter.value": "Mint"}, nil, errInvalidCondition},
+	}
+	for _, tt := range tests {
+		params := maps.Clone(tt.params)
+		err := normalizeConditions(params)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("normalizeConditions(%v) = %v, want %v", tt.params, err, tt.err)
+			continue
+		}
+		if err == nil && !maps.Equal(params, tt.want) {
+			t.Errorf("normalizeConditions(%v) = %v, want %v", tt.params, params, tt.want)
+		}
+	}
+}
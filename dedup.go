@@ -0,0 +1,29 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import "github.com/matthewdargan/ebay"
+
+// duplicateItems returns the number of items in rs whose item ID appeared
+// earlier in rs, as when a listing moves between pages during a run.
+func duplicateItems(rs []ebay.FindItemsResponse) int {
+	seen := make(map[string]bool)
+	var n int
+	for _, r := range rs {
+		if len(r.SearchResult) == 0 {
+			continue
+		}
+		for _, it := range r.SearchResult[0].Item {
+			id := first(it.ItemID)
+			if id == "" {
+				continue
+			}
+			if seen[id] {
+				n++
+			}
+			seen[id] = true
+		}
+	}
+	return n
+}
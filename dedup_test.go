@@ -0,0 +1,21 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"testing"
+
+	"github.com/matthewdargan/ebay"
+)
+
+func TestDuplicateItems(t *testing.T) {
+	t.Parallel()
+	rs := []ebay.FindItemsResponse{
+		testPage(1, 2, testItem("1"), testItem("2"), testItem("1")),
+		testPage(2, 2, testItem("2"), testItem("3")),
+	}
+	if n := duplicateItems(rs); n != 2 {
+		t.Errorf("duplicateItems = %d, want 2", n)
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const kmPerMile = 1.609344
+
+// applyDistanceUnit rewrites the MaxDistance item filter in params, which
+// eBay interprets in miles, from unit to miles. The unit must be mi or km.
+func applyDistanceUnit(params map[string]string, unit string) error {
+	if unit != "mi" && unit != "km" {
+		return fmt.Errorf("invalid distance unit %q: must be mi or km", unit)
+	}
+	if unit == "mi" {
+		return nil
+	}
+	for k, v := range params {
+		if v != "MaxDistance" || !isItemFilterName(k) {
+			continue
+		}
+		valueKey := k[:len(k)-len("name")] + "value"
+		d, err := strconv.ParseFloat(params[valueKey], 64)
+		if err != nil {
+			return fmt.Errorf("cannot convert MaxDistance value to float64: %w", err)
+		}
+		params[valueKey] = strconv.Itoa(int(math.Round(convertDistance(d, unit, "mi"))))
+	}
+	return nil
+}
+
+// convertDistance converts d from unit from to unit to.
+func convertDistance(d float64, from, to string) float64 {
+	switch {
+	case from == "mi" && to == "km":
+		return d * kmPerMile
+	case from == "km" && to == "mi":
+		return d / kmPerMile
+	}
+	return d
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/matthewdargan/ebay"
+)
+
+func TestApplyDistanceUnit(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		unit, value, want string
+	}{
+		{"mi", "10", "10"},
+		{"km", "10", "6"},
+		{"km", "16", "10"},
+		{"km", "2.5", "2"},
+	}
+	for _, tt := range tests {
+		params := map[string]string{"itemFilter(0).name": "MaxDistance", "itemFilter(0).value": tt.value}
+		if err := applyDistanceUnit(params, tt.unit); err != nil {
+			t.Errorf("applyDistanceUnit(%s) with MaxDistance %s: %v", tt.unit, tt.value, err)
+			continue
+		}
+		if got := params["itemFilter(0).value"]; got != tt.want {
+			t.Errorf("applyDistanceUnit(%s) with MaxDistance %s = %s, want %s", tt.unit, tt.value, got, tt.want)
+		}
+	}
+	params := map[string]string{"itemFilter.name": "MaxDistance", "itemFilter.value": "10"}
+	if err := applyDistanceUnit(params, "yd"); err == nil {
+		t.Error("applyDistanceUnit(yd) succeeded, want error")
+	}
+}
+
+//nolint:paralleltest // Sets -distance-unit.
+func TestItemDistanceUnit(t *testing.T) {
+	tests := []struct {
+		unit  string
+		from  ebay.Distance
+		value float64
+	}{
+		{"mi", ebay.Distance{Unit: "mi", Value: "10"}, 10},
+		{"km", ebay.Distance{Unit: "mi", Value: "10"}, 16.09344},
+		{"mi", ebay.Distance{Unit: "km", Value: "16.09344"}, 10},
+		{"km", ebay.Distance{Unit: "km", Value: "5"}, 5},
+	}
+	for _, tt := range tests {
+		setVar(t, distUnit, tt.unit)
+		it := testItem("1")
+		it.Distance = []ebay.Distance{tt.from}
+		got, err := item(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.distanceUnit == nil || *got.distanceUnit != tt.unit {
+			t.Errorf("-distance-unit %s: distanceUnit = %v, want %s", tt.unit, got.distanceUnit, tt.unit)
+		}
+		if got.distanceValue == nil || math.Abs(*got.distanceValue-tt.value) > 1e-9 {
+			t.Errorf("-distance-unit %s with %s %s: distanceValue = %v, want %v", tt.unit, tt.from.Value, tt.from.Unit, got.distanceValue, tt.value)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// explainParams writes a description of the request for the operation op
+// with params to w, redacting the eBay application ID.
+func explainParams(w io.Writer, op string, params map[string]string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "operation\t%s\n", op)
+	for _, f := range itemFilters(params) {
+		fmt.Fprintf(tw, "item filter\t%s = %s", f.name, strings.Join(f.values, ", "))
+		if f.paramName != "" {
+			fmt.Fprintf(tw, " (%s = %s)", f.paramName, f.paramValue)
+		}
+		fmt.Fprintln(tw)
+	}
+	for _, f := range aspectFilters(params) {
+		fmt.Fprintf(tw, "aspect filter\t%s = %s\n", f.name, strings.Join(f.values, ", "))
+	}
+	if v, ok := params["sortOrder"]; ok {
+		fmt.Fprintf(tw, "sort order\t%s\n", v)
+	}
+	for _, k := range []string{"paginationInput.entriesPerPage", "paginationInput.pageNumber"} {
+		if v, ok := params[k]; ok {
+			fmt.Fprintf(tw, "pagination\t%s = %s\n", strings.TrimPrefix(k, "paginationInput."), v)
+		}
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		switch {
+		case strings.HasPrefix(k, "itemFilter"), strings.HasPrefix(k, "aspectFilter"),
+			strings.HasPrefix(k, "paginationInput."), k == "sortOrder":
+			continue
+		case strings.EqualFold(k, "Security-AppName"):
+			fmt.Fprintf(tw, "param\t%s = REDACTED\n", k)
+		default:
+			fmt.Fprintf(tw, "param\t%s = %s\n", k, params[k])
+		}
+	}
+	tw.Flush()
+}
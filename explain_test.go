@@ -0,0 +1,35 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExplainParams(t *testing.T) {
+	t.Parallel()
+	params := map[string]string{
+		"keywords":                 "phone",
+		"itemFilter(0).name":       "Condition",
+		"itemFilter(0).value(0)":   "New",
+		"itemFilter(0).value(1)":   "Used",
+		"itemFilter(1).name":       "MaxPrice",
+		"itemFilter(1).value":      "100",
+		"itemFilter(1).paramName":  "Currency",
+		"itemFilter(1).paramValue": "USD",
+		"Security-AppName":         "app",
+	}
+	var buf bytes.Buffer
+	explainParams(&buf, "findItemsByKeywords", params)
+	want := `operation    findItemsByKeywords
+item filter  Condition = New, Used
+item filter  MaxPrice = 100 (Currency = USD)
+param        Security-AppName = REDACTED
+param        keywords = phone
+`
+	if got := buf.String(); got != want {
+		t.Errorf("explainParams wrote\n%s\nwant\n%s", got, want)
+	}
+}
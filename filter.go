@@ -0,0 +1,130 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// An itemFilter is an item filter parsed from request params, such as
+// itemFilter(0).name=MaxPrice&itemFilter(0).value=10.
+type itemFilter struct {
+	name       string
+	values     []string
+	paramName  string
+	paramValue string
+}
+
+// itemFilters returns the item filters in params in index order, with an
+// unnumbered itemFilter first.
+func itemFilters(params map[string]string) []itemFilter {
+	groups := paramGroups(params, "itemFilter")
+	filters := make([]itemFilter, len(groups))
+	for i, g := range groups {
+		filters[i] = itemFilter{
+			name:       first(g["name"]),
+			values:     g["value"],
+			paramName:  first(g["paramName"]),
+			paramValue: first(g["paramValue"]),
+		}
+	}
+	return filters
+}
+
+// An aspectFilter is an aspect filter parsed from request params, such as
+// aspectFilter(0).aspectName=Brand&aspectFilter(0).aspectValueName=Apple.
+type aspectFilter struct {
+	name   string
+	values []string
+}
+
+// aspectFilters returns the aspect filters in params in index order, with
+// an unnumbered aspectFilter first.
+func aspectFilters(params map[string]string) []aspectFilter {
+	groups := paramGroups(params, "aspectFilter")
+	filters := make([]aspectFilter, len(groups))
+	for i, g := range groups {
+		filters[i] = aspectFilter{
+			name:   first(g["aspectName"]),
+			values: g["aspectValueName"],
+		}
+	}
+	return filters
+}
+
+// paramGroups groups the params named name.field or name(N).field by their
+// prefix, in index order with an unnumbered prefix first. Each group maps a
+// field name to its values, which are ordered by index when the field is
+// numbered, as in value(0) and value(1).
+func paramGroups(params map[string]string, name string) []map[string][]string {
+	type indexedValue struct {
+		index int
+		value string
+	}
+	type group struct {
+		index  int
+		fields map[string][]indexedValue
+	}
+	byPrefix := make(map[string]*group)
+	for k, v := range params {
+		prefix, field, ok := strings.Cut(k, ".")
+		if !ok || paramName(prefix) != name {
+			continue
+		}
+		g := byPrefix[prefix]
+		if g == nil {
+			g = &group{index: paramIndex(prefix), fields: make(map[string][]indexedValue)}
+			byPrefix[prefix] = g
+		}
+		f := paramName(field)
+		g.fields[f] = append(g.fields[f], indexedValue{paramIndex(field), v})
+	}
+	gs := make([]*group, 0, len(byPrefix))
+	for _, g := range byPrefix {
+		gs = append(gs, g)
+	}
+	slices.SortFunc(gs, func(a, b *group) int { return cmp.Compare(a.index, b.index) })
+	groups := make([]map[string][]string, len(gs))
+	for i, g := range gs {
+		groups[i] = make(map[string][]string, len(g.fields))
+		for f, vs := range g.fields {
+			slices.SortFunc(vs, func(a, b indexedValue) int { return cmp.Compare(a.index, b.index) })
+			for _, v := range vs {
+				groups[i][f] = append(groups[i][f], v.value)
+			}
+		}
+	}
+	return groups
+}
+
+// paramName returns the name of a possibly numbered param, as in itemFilter
+// for itemFilter(0).
+func paramName(s string) string {
+	name, _, _ := strings.Cut(s, "(")
+	return name
+}
+
+// paramIndex returns the index of a numbered param, as in 0 for
+// itemFilter(0), or -1 if s is not numbered.
+func paramIndex(s string) int {
+	_, rest, ok := strings.Cut(s, "(")
+	if !ok {
+		return -1
+	}
+	i, err := strconv.Atoi(strings.TrimSuffix(rest, ")"))
+	if err != nil {
+		return -1
+	}
+	return i
+}
+
+func first(ss []string) string {
+	if len(ss) > 0 {
+		return ss[0]
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errEmptyKeywords = errors.New("keywords are empty once operators are stripped")
+
+// keywordOperators replaces the characters eBay treats as keyword operators.
+var keywordOperators = strings.NewReplacer(`"`, " ", "(", " ", ")", " ", ",", " ", "*", " ", "@", " ")
+
+// applyKeywordsMode rewrites the keywords in params for mode. In raw mode
+// keywords are passed through untouched. In literal mode eBay keyword
+// operators are stripped so the words match as written, and keywords made
+// only of operators are rejected.
+func applyKeywordsMode(params map[string]string, mode string) error {
+	switch mode {
+	case "raw":
+		return nil
+	case "literal":
+		kw, ok := params["keywords"]
+		if !ok {
+			return nil
+		}
+		words := strings.Fields(keywordOperators.Replace(kw))
+		for i, w := range words {
+			words[i] = strings.TrimLeft(w, "-")
+		}
+		literal := strings.Join(strings.Fields(strings.Join(words, " ")), " ")
+		if literal == "" {
+			return fmt.Errorf("%w: %q", errEmptyKeywords, kw)
+		}
+		params["keywords"] = literal
+		return nil
+	}
+	return fmt.Errorf("invalid keywords mode %q: must be raw or literal", mode)
+}
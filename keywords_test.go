@@ -0,0 +1,46 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApplyKeywordsMode(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		mode, keywords, want string
+		err                  error
+	}{
+		{"raw", `"iphone 15" (pro,max) -case`, `"iphone 15" (pro,max) -case`, nil},
+		{"raw", "()", "()", nil},
+		{"literal", `"iphone 15" (pro,max) -case`, "iphone 15 pro max case", nil},
+		{"literal", "c@t* -", "c t", nil},
+		{"literal", "phone", "phone", nil},
+		{"literal", "()", "", errEmptyKeywords},
+		{"literal", `"" -`, "", errEmptyKeywords},
+	}
+	for _, tt := range tests {
+		params := map[string]string{"keywords": tt.keywords}
+		err := applyKeywordsMode(params, tt.mode)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("applyKeywordsMode(%q, %s) = %v, want %v", tt.keywords, tt.mode, err, tt.err)
+			continue
+		}
+		if err == nil && params["keywords"] != tt.want {
+			t.Errorf("applyKeywordsMode(%q, %s) keywords = %q, want %q", tt.keywords, tt.mode, params["keywords"], tt.want)
+		}
+	}
+	params := map[string]string{"categoryId": "9355"}
+	if err := applyKeywordsMode(params, "literal"); err != nil {
+		t.Errorf("applyKeywordsMode without keywords = %v, want nil", err)
+	}
+	if _, ok := params["keywords"]; ok {
+		t.Error("applyKeywordsMode added keywords")
+	}
+	if err := applyKeywordsMode(map[string]string{"keywords": "phone"}, "exact"); err == nil {
+		t.Error("applyKeywordsMode(exact) succeeded, want error")
+	}
+}
@@ -6,9 +6,210 @@
 //
 // Usage:
 //
-//	swippy {advanced|category|keyword|product|ebay-store} params
+//	swippy [flags] {advanced|category|keyword|product|ebay-store} params
+//	swippy [flags] validate {advanced|category|keyword|product|ebay-store} params
 //
-// The “EBAY_APP_ID” and “DB_URL” environment variables are required.
+// The validate command checks params without contacting eBay or the
+// database, printing “ok” or the validation error.
+//
+// The flags are:
+//
+//	-affiliate params
+//		Add the eBay Partner Network tracking params, given as a query
+//		string such as campid=5338000000&mkcid=1, to each stored view item
+//		URL so that clicks earn commission. campid is required.
+//
+//	-alias name=operation[:params]
+//		Register name as an alias for operation, presetting params that
+//		the params argument may override. The name may not be that of a
+//		built-in operation. The flag may be repeated.
+//
+//	-api-version version
+//		Warn when eBay responds with an API version other than version,
+//		since response shapes may change between versions.
+//
+//	-audit-fields
+//		Log the first field in each eBay response that swippy does not
+//		model, to notice when eBay changes its responses. The field is
+//		still ignored when storing items.
+//
+//	-change-log
+//		With -upsert, record the old and new price, watch count, and selling
+//		state of each stored item whose values a run changes, such as a
+//		price drop, in the item_changes table created by
+//		sql/create-item-changes.sql.
+//
+//	-checkpoint file
+//		Record in file the last page of results stored for the query, and
+//		start a later run of the same query at the page after it, so that
+//		a pull stopped by -max-pages, -max-duration, or a failed page
+//		resumes rather than restarting. The file is written only after the
+//		items are stored, so a run killed while fetching records nothing
+//		and is repeated in full. The file is removed once every page has
+//		been stored, and is not updated by -dry-run.
+//
+//	-column column=name
+//		Copy the item table column, such as item_id, into the column
+//		called name instead, to use an existing table whose columns are
+//		named differently. The flag may be repeated. Columns not mapped
+//		keep their names, and -format output always uses the default names.
+//
+//	-distance-unit unit
+//		Interpret the MaxDistance item filter and store item distances
+//		in unit, either mi or km (default mi).
+//
+//	-dry-run
+//		Fetch and convert items without storing them, reporting how many
+//		would be inserted. DB_URL is not required.
+//
+//	-ending-within duration
+//		Keep only listings that end within duration of now, such as 1h
+//		for auctions ending soon.
+//
+//	-explain
+//		Print the parsed item filters, aspect filters, sort order,
+//		pagination, and remaining params to standard error before making
+//		the request.
+//
+//	-flatten-shipping
+//		Store one row per shipping option when an item has several
+//		shipping service costs, instead of only the first. The rows share
+//		the item ID, so they cannot be stored once the item table has the
+//		unique index on item_id that -upsert requires.
+//
+//	-format format
+//		Print the converted items to standard output as they are stored,
+//		as a JSON array (json), one JSON object per line (ndjson), or an
+//		aligned table of item ID, title, and current price (table). JSON
+//		keys match the item table column names. By default nothing is
+//		printed.
+//
+//	-indent n
+//		Indent the JSON array printed by -format json by n spaces per
+//		level, or print it compactly if n is 0 (default 2).
+//
+//	-keywords-mode mode
+//		Pass keywords to eBay untouched (raw), so that operators like
+//		"exact phrase", (a,b) groups, and -exclude apply, or strip the
+//		operators so keywords match literally (literal) (default raw).
+//		Literal keywords that are only operators, such as (), are
+//		rejected.
+//
+//	-max-attempts n
+//		Attempt each eBay request at most n times in all, retrying network
+//		errors, non-JSON responses, 429 and 5xx statuses, and eBay errors
+//		that are failures within eBay, in the System category or the
+//		ServiceError domain (default 3). Throttled requests are retried
+//		after the delay given by the Retry-After header, if any.
+//
+//	-max-duration duration
+//		Stop fetching pages once the run has taken duration, storing the
+//		items fetched so far. By default runs are not limited.
+//
+//	-max-error-rate fraction
+//		Abort the run when more than fraction of the items in a response
+//		fail to convert, which usually means eBay changed the response
+//		format (default 1, never abort).
+//
+//	-max-pages n
+//		Fetch at most n pages of results (default 100, the most eBay
+//		returns). Paging starts at paginationInput.pageNumber, if given,
+//		and stops after page 100, the last eBay serves. If a page after
+//		the first fails, paging stops and the pages before it are stored.
+//
+//	-max-retry-after duration
+//		Wait at most duration when eBay throttles a request with a 429
+//		status and a Retry-After header asking for a longer wait
+//		(default 5s). Requests time out after 10s, retries included.
+//
+//	-normalize-title
+//		Store a lowercased, whitespace-collapsed copy of each title in the
+//		title_normalized column for case-insensitive search.
+//
+//	-optional-text policy
+//		Store absent optional text fields, such as location and subtitle,
+//		as NULL (null) or as empty strings (empty) (default null).
+//
+//	-page-delay delay
+//		Wait delay between fetching pages of results, so that deep pulls
+//		stay under eBay's per-second rate limits (default 100ms).
+//
+//	-quiet
+//		Log only errors and the run summary, leaving out warnings. It
+//		cannot be used with -verbose.
+//
+//	-require-image
+//		Drop items that have neither a gallery nor a large picture URL.
+//
+//	-retry-delay delay
+//		Wait delay before the first retry of a failed eBay request,
+//		doubling the wait for each further retry, with jitter
+//		(default 500ms).
+//
+//	-strict
+//		Treat consistency warnings about params as errors. The warnings
+//		name a currency filter or price filter currency that is not the
+//		site's currency, a buyerPostalCode that is not in the postal
+//		code format of the site's country, or an ExcludeCategory value of
+//		0, which is not a category ID.
+//
+//	-timestamp-source source
+//		Store the eBay response time (ebay) or the ingestion time (ingest)
+//		in the timestamp column (default ebay). The ingestion time is
+//		always stored in the ingested_at column.
+//
+//	-total-cost
+//		Store the current price plus the shipping service cost in the
+//		total_cost column, for comparing what items cost delivered. The
+//		column is NULL when shipping is absent or the price and shipping
+//		are in different currencies.
+//
+//	-upsert
+//		Update the stored row of an item already in the item table, such
+//		as its price and watch count, instead of inserting a duplicate.
+//		This requires the unique index on item_id created by
+//		sql/create-item-id-index.sql, and cannot be used with
+//		-flatten-shipping. Once the index exists, every run must use
+//		-upsert: a run without it stores nothing if it fetches an item
+//		already stored, or the same item twice, as when a listing moves
+//		between pages. The run summary reports how many stored items were
+//		updated.
+//
+//	-url url
+//		Send requests to the eBay Finding API endpoint at url instead of
+//		production, such as the sandbox endpoint
+//		https://svcs.sandbox.ebay.com/services/search/FindingService/v1.
+//		The url may include a path and query, as for a gateway that
+//		proxies eBay, and request params are added to its query.
+//
+//	-verbose
+//		Log the full eBay responses and progress, such as the eBay API
+//		version and retries, to standard error. By default only warnings
+//		and the run summary are printed.
+//
+// The “EBAY_APP_ID” and “DB_URL” environment variables are required, except
+// that “DB_URL” is unused with -dry-run. If “EBAY_APP_ID” is unset, the app
+// ID is read from the file named by “EBAY_APP_ID_FILE”, such as a mounted
+// secret.
+//
+// Items are stored in the item table created by sql/create-item.sql. A table
+// created by an earlier version of swippy is brought up to date by
+// sql/upgrade-item.sql. sql/create-item-end-time-index.sql indexes listing end
+// times, for querying auctions that end soon.
+//
+// Item filters that accept several values, namely Condition, ExcludeCategory,
+// ExcludeSeller, ListingType, LocatedIn, and Seller, may give them separated
+// by commas, as in itemFilter.value=1,2,3, instead of as numbered values. Other
+// item filters accept one value.
+//
+// Params may include outputSelector, or numbered outputSelector(0),
+// outputSelector(1), and so on, to request more data about each item:
+// GalleryInfo adds galleryInfoContainer, PictureURLLarge and
+// PictureURLSuperSize add larger picture URLs, SellerInfo adds sellerInfo,
+// StoreInfo adds storeInfo, and UnitPriceInfo adds unitPrice.
+// AspectHistogram, CategoryHistogram, and ConditionHistogram add histograms
+// to the response instead. -require-image only sees large picture URLs when
+// PictureURLLarge is selected.
 //
 // Examples:
 //
@@ -19,121 +220,765 @@
 // Retrieve phones by category:
 //
 //	$ swippy category 'categoryId=9355'
+//
+// Retrieve phones by category, nearest to a postal code first:
+//
+//	$ swippy category 'categoryId=9355&buyerPostalCode=10001&sortOrder=DistanceNearest'
+//
+// Retrieve phones from eBay UK by numeric site ID:
+//
+//	$ swippy keyword 'keywords=phone&siteId=3'
+//
+// Exclude several categories with a comma-separated item filter value:
+//
+//	$ swippy keyword 'keywords=phone&itemFilter.name=ExcludeCategory&itemFilter.value=1,2,3'
+//
+// Retrieve listings of an eBay catalog product by ePID:
+//
+//	$ swippy product 'productId.@type=ReferenceID&productId=4034210179'
+//
+// Retrieve phones from an eBay store:
+//
+//	$ swippy ebay-store 'storeName=Best Buy&keywords=phone'
+//
+// Retrieve phones whose sales benefit a charity:
+//
+//	$ swippy keyword 'keywords=phone&charityId=10484'
+//
+// Retrieve books by ISBN through an alias:
+//
+//	$ swippy -alias 'books=product:productId.@type=ISBN' books 'productId=9780131103627'
+//
+// Request seller details and large pictures for each item:
+//
+//	$ swippy keyword 'keywords=phone&outputSelector(0)=SellerInfo&outputSelector(1)=PictureURLLarge'
+//
+// Retrieve used phones, giving the condition by name or ID:
+//
+//	$ swippy keyword 'keywords=phone&itemFilter.name=Condition&itemFilter.value=Used'
+//
+// Check a query before running it:
+//
+//	$ swippy validate keyword 'keywords=phone&sortOrder=DistanceNearest'
 package main
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/lib/pq"
 	"github.com/matthewdargan/ebay"
 )
 
+// A finder searches for items with the eBay Finding API. It is implemented
+// by *ebay.FindingClient and may be replaced to run operations without eBay.
+type finder interface {
+	FindItemsAdvanced(ctx context.Context, params map[string]string) (*ebay.FindItemsAdvancedResponse, error)
+	FindItemsByCategory(ctx context.Context, params map[string]string) (*ebay.FindItemsByCategoryResponse, error)
+	FindItemsByKeywords(ctx context.Context, params map[string]string) (*ebay.FindItemsByKeywordsResponse, error)
+	FindItemsByProduct(ctx context.Context, params map[string]string) (*ebay.FindItemsByProductResponse, error)
+	FindItemsInEBayStores(ctx context.Context, params map[string]string) (*ebay.FindItemsInEBayStoresResponse, error)
+}
+
+// An operation runs an eBay Finding API call. Its preset params are used
+// where the caller's params do not set them. The name is that of the
+// built-in operation, even for aliases.
+type operation struct {
+	name   string
+	find   func(ctx context.Context, c finder, params map[string]string) ([]ebay.FindItemsResponse, error)
+	preset map[string]string
+}
+
+// operations maps operation names, including aliases, to operations.
+var operations = map[string]operation{
+	"advanced": {name: "advanced", find: func(ctx context.Context, c finder, params map[string]string) ([]ebay.FindItemsResponse, error) {
+		r, err := c.FindItemsAdvanced(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		return r.ItemsResponse, nil
+	}},
+	"category": {name: "category", find: func(ctx context.Context, c finder, params map[string]string) ([]ebay.FindItemsResponse, error) {
+		r, err := c.FindItemsByCategory(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		return r.ItemsResponse, nil
+	}},
+	"keyword": {name: "keyword", find: func(ctx context.Context, c finder, params map[string]string) ([]ebay.FindItemsResponse, error) {
+		r, err := c.FindItemsByKeywords(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		return r.ItemsResponse, nil
+	}},
+	"product": {name: "product", find: func(ctx context.Context, c finder, params map[string]string) ([]ebay.FindItemsResponse, error) {
+		r, err := c.FindItemsByProduct(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		return r.ItemsResponse, nil
+	}},
+	"ebay-store": {name: "ebay-store", find: func(ctx context.Context, c finder, params map[string]string) ([]ebay.FindItemsResponse, error) {
+		r, err := c.FindItemsInEBayStores(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		return r.ItemsResponse, nil
+	}},
+}
+
+// findAll runs op with params for each page of results, starting at
+// paginationInput.pageNumber or the first page and waiting -page-delay
+// between pages. It stops when eBay has no more pages, the last page eBay
+// serves or maxPages pages have been fetched, ctx is done, or a page fails.
+// The pages fetched before ctx is done or a later page fails are returned
+// without error, while a first page with eBay errors is returned for the
+// caller to report. It also returns the page after the last page fetched,
+// or 0 if eBay has no more pages.
+func findAll(ctx context.Context, c finder, op operation, params map[string]string, maxPages int) ([]ebay.FindItemsResponse, int, error) {
+	page := 1
+	if p, ok := params["paginationInput.pageNumber"]; ok {
+		var err error
+		page, err = strconv.Atoi(p)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid paginationInput.pageNumber %q: %w", p, err)
+		}
+	}
+	var resps []ebay.FindItemsResponse
+	for i := range maxPages {
+		if page > maxPagination {
+			return resps, 0, nil
+		}
+		if i > 0 {
+			if err := sleep(ctx, *pageDelay); err != nil {
+				warnf("stopping at page %d: %v", page, err)
+				return resps, page, nil
+			}
+		}
+		pageParams := maps.Clone(params)
+		pageParams["paginationInput.pageNumber"] = strconv.Itoa(page)
+		rs, err := findPage(ctx, c, op, pageParams)
+		if err == nil && len(rs) > 0 && len(resps) > 0 {
+			err = responseError(rs[0])
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			} else if len(resps) == 0 {
+				return nil, 0, err
+			}
+			warnf("stopping at page %d: %v", page, err)
+			return resps, page, nil
+		}
+		resps = append(resps, rs...)
+		if len(rs) > 0 && responseError(rs[0]) != nil {
+			return resps, page, nil
+		}
+		if len(rs) == 0 || page >= totalPages(rs[0]) {
+			return resps, 0, nil
+		}
+		page++
+	}
+	if page > maxPagination {
+		return resps, 0, nil
+	}
+	return resps, page, nil
+}
+
+// findPage runs op with params, retrying with backoff while eBay responds
+// with a temporary error. The request is sent at most -max-attempts times in
+// all, counting the retries of the transport.
+func findPage(ctx context.Context, c finder, op operation, params map[string]string) ([]ebay.FindItemsResponse, error) {
+	cl := &call{limit: *maxAttempts}
+	ctx = withCall(ctx, cl)
+	for {
+		n := cl.attempts
+		rs, err := op.find(ctx, c, params)
+		if cl.attempts == n {
+			// c does not send requests through the transport.
+			cl.attempts++
+		}
+		if err != nil && cl.err != nil {
+			err = &requestError{err: err, transport: cl.err}
+		}
+		if err != nil || len(rs) == 0 || cl.attempts >= cl.limit {
+			return rs, err
+		}
+		var e *apiError
+		if !errors.As(responseError(rs[0]), &e) || !e.temporary() {
+			return rs, nil
+		}
+		infof("retrying page %s: %v", params["paginationInput.pageNumber"], e)
+		if err = sleep(ctx, backoff(*retryDelay, cl.attempts)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// totalPages returns the total number of result pages reported in r, or 0
+// if r does not report it.
+func totalPages(r ebay.FindItemsResponse) int {
+	if len(r.PaginationOutput) == 0 {
+		return 0
+	}
+	n, err := strconv.Atoi(first(r.PaginationOutput[0].TotalPages))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// registerAlias registers an operation alias given as name=operation or
+// name=operation:params, where params are preset for the alias. The name
+// may not be that of a built-in operation or the validate command.
+func registerAlias(s string) error {
+	name, def, ok := strings.Cut(s, "=")
+	if !ok || name == "" || name == "validate" {
+		return fmt.Errorf("invalid alias %q", s)
+	}
+	if op, ok := operations[name]; ok && op.name == name {
+		return fmt.Errorf("invalid alias %q: %s is a built-in operation", s, name)
+	}
+	opName, ps, _ := strings.Cut(def, ":")
+	op, ok := operations[opName]
+	if !ok {
+		return fmt.Errorf("unknown operation %q", opName)
+	}
+	preset := make(map[string]string)
+	maps.Copy(preset, op.preset)
+	if ps != "" {
+		params, err := parseParams(ps)
+		if err != nil {
+			return err
+		}
+		maps.Copy(preset, params)
+	}
+	operations[name] = operation{name: op.name, find: op.find, preset: preset}
+	return nil
+}
+
+var (
+	audit        = flag.Bool("audit-fields", false, "log response fields the ebay package does not model")
+	changeLog    = flag.Bool("change-log", false, "with -upsert, record changes to stored prices, watch counts, and selling states")
+	resumeFile   = flag.String("checkpoint", "", "record the last page stored in `file` and resume after it")
+	apiVersion   = flag.String("api-version", "", "warn when eBay responds with an API `version` other than this")
+	dryRun       = flag.Bool("dry-run", false, "fetch and convert items without storing them")
+	distUnit     = flag.String("distance-unit", "mi", "`unit` for MaxDistance and stored distances (mi or km)")
+	explain      = flag.Bool("explain", false, "print the parsed request before making it")
+	endingWithin = flag.Duration("ending-within", 0, "keep only listings ending within `duration`")
+	maxDuration  = flag.Duration("max-duration", 0, "stop fetching pages after `duration` and store what was fetched")
+	maxErrorRate = flag.Float64("max-error-rate", 1, "abort when more than `fraction` of a response's items fail to convert")
+	textPolicy   = flag.String("optional-text", "null", "`policy` for absent optional text (null or empty)")
+	retryDelay   = flag.Duration("retry-delay", 500*time.Millisecond, "base `delay` between retries of failed eBay requests")
+	requireImage = flag.Bool("require-image", false, "drop items without a gallery or large picture URL")
+	strict       = flag.Bool("strict", false, "treat consistency warnings about params as errors")
+	indent       = flag.Int("indent", 2, "indent -format json output by `n` spaces, or 0 for compact output")
+	format       = flag.String("format", "", "print items to standard output in `format` (json, ndjson, or table)")
+	flatShipping = flag.Bool("flatten-shipping", false, "store one row per shipping service cost")
+	findingURL   = flag.String("url", "", "eBay Finding API endpoint `url`, such as the sandbox endpoint")
+	storeTotal   = flag.Bool("total-cost", false, "store the current price plus shipping cost in the total_cost column")
+	upsert       = flag.Bool("upsert", false, "update stored items with the same item ID instead of inserting duplicates")
+	verbose      = flag.Bool("verbose", false, "log the full eBay responses and progress such as retries")
+	quiet        = flag.Bool("quiet", false, "log only errors and the run summary")
+	tsSource     = flag.String("timestamp-source", "ebay", "`source` of the timestamp column (ebay or ingest)")
+	maxAttempts  = flag.Int("max-attempts", 3, "attempt each eBay request at most `n` times")
+	maxRetryWait = flag.Duration("max-retry-after", 5*time.Second, "wait at most `duration` for a Retry-After header")
+	maxPages     = flag.Int("max-pages", 100, "fetch at most `n` pages of results")
+	pageDelay    = flag.Duration("page-delay", 100*time.Millisecond, "wait `delay` between pages of results")
+	normTitle    = flag.Bool("normalize-title", false, "store a lowercased, whitespace-collapsed title for search")
+	keywordsMode = flag.String("keywords-mode", "raw", "keywords `mode`: raw passes eBay operators through, literal strips them")
+)
+
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: swippy {advanced|category|keyword|product|ebay-store} params\n")
+	fmt.Fprintf(os.Stderr, "usage: swippy [flags] {advanced|category|keyword|product|ebay-store} params\n")
+	fmt.Fprintf(os.Stderr, "       swippy [flags] validate {advanced|category|keyword|product|ebay-store} params\n")
+	flag.PrintDefaults()
 	os.Exit(2)
 }
 
+// infof logs progress that is shown only with -verbose.
+func infof(format string, v ...any) {
+	if *verbose {
+		log.Printf(format, v...)
+	}
+}
+
+// warnf logs a warning unless -quiet is set.
+func warnf(format string, v ...any) {
+	if !*quiet {
+		log.Printf(format, v...)
+	}
+}
+
 func main() {
 	log.SetPrefix("swippy: ")
 	log.SetFlags(0)
 	flag.Usage = usage
+	flag.Func("affiliate", "add eBay Partner Network tracking `params` to stored view item URLs", setAffiliateParams)
+	flag.Func("alias", "register `name=operation[:params]` as an operation with preset params", registerAlias)
+	flag.Func("column", "store an item table column under another name, given as `column=name`", setColumnName)
 	flag.Parse()
+	start := time.Now()
+	if err := checkColumnNames(); err != nil {
+		log.Fatal(err)
+	}
+	if *tsSource != "ebay" && *tsSource != "ingest" {
+		log.Fatalf("invalid timestamp source %q: must be ebay or ingest", *tsSource)
+	}
+	if *textPolicy != "null" && *textPolicy != "empty" {
+		log.Fatalf("invalid optional text policy %q: must be null or empty", *textPolicy)
+	}
+	if *quiet && *verbose {
+		log.Fatal("-quiet cannot be used with -verbose")
+	}
+	if *upsert && *flatShipping {
+		log.Fatal("-upsert cannot be used with -flatten-shipping, which stores several rows per item")
+	}
+	if *changeLog && !*upsert {
+		log.Fatal("-change-log requires -upsert")
+	}
+	writeItem, flushItems, err := newItemWriter(os.Stdout, *format, *indent)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flag.NArg() == 3 && flag.Arg(0) == "validate" {
+		if _, err := loadParams(flag.Arg(1), flag.Arg(2)); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("ok")
+		return
+	}
 	if flag.NArg() != 2 {
 		usage()
 	}
-	queryParams, err := parseParams(flag.Arg(1))
+	queryParams, err := loadParams(flag.Arg(0), flag.Arg(1))
 	if err != nil {
 		log.Fatal(err)
 	}
-	c := ebay.NewFindingClient(&http.Client{Timeout: time.Second * 10}, os.Getenv("EBAY_APP_ID"))
-	var resps []ebay.FindItemsResponse
-	switch flag.Arg(0) {
-	case "advanced":
-		var r *ebay.FindItemsAdvancedResponse
-		r, err = c.FindItemsAdvanced(context.Background(), queryParams)
+	query := querySignature(operations[flag.Arg(0)].name, queryParams)
+	if *resumeFile != "" {
+		page, err := resumePage(*resumeFile, query)
 		if err != nil {
 			log.Fatal(err)
 		}
-		resps = r.ItemsResponse
-	case "category":
-		var r *ebay.FindItemsByCategoryResponse
-		r, err = c.FindItemsByCategory(context.Background(), queryParams)
-		if err != nil {
-			log.Fatal(err)
+		if page > 0 {
+			infof("resuming at page %d", page)
+			queryParams["paginationInput.pageNumber"] = strconv.Itoa(page)
 		}
-		resps = r.ItemsResponse
-	case "keyword":
-		var r *ebay.FindItemsByKeywordsResponse
-		r, err = c.FindItemsByKeywords(context.Background(), queryParams)
-		if err != nil {
-			log.Fatal(err)
+	}
+	if *explain {
+		explainParams(os.Stderr, flag.Arg(0), queryParams)
+	}
+	id, err := appID()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tr := &transport{
+		base:          http.DefaultTransport,
+		maxAttempts:   *maxAttempts,
+		baseDelay:     *retryDelay,
+		maxRetryAfter: *maxRetryWait,
+		audit:         *audit,
+	}
+	c, err := newFindingClient(tr, id, *findingURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	if *maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *maxDuration)
+		defer cancel()
+	}
+	resps, next, err := findAll(ctx, c, operations[flag.Arg(0)], queryParams, *maxPages)
+	if errors.Is(err, errNonJSONResponse) {
+		log.Fatalf("%v; eBay may be down for maintenance", err)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(resps) == 0 {
+		if *resumeFile != "" && !*dryRun {
+			if err = saveCheckpoint(*resumeFile, query, 0); err != nil {
+				log.Fatal(err)
+			}
 		}
-		resps = r.ItemsResponse
-	case "product":
-		var r *ebay.FindItemsByProductResponse
-		r, err = c.FindItemsByProduct(context.Background(), queryParams)
-		if err != nil {
+		os.Exit(0)
+	}
+	logWarnings(resps, tr.deprecations)
+	logVersion(resps, *apiVersion)
+	for _, r := range resps {
+		if err = responseError(r); err != nil {
 			log.Fatal(err)
 		}
-		resps = r.ItemsResponse
-	case "ebay-store":
-		var r *ebay.FindItemsInEBayStoresResponse
-		r, err = c.FindItemsInEBayStores(context.Background(), queryParams)
-		if err != nil {
+	}
+	if *verbose {
+		log.Print(resps)
+	}
+	reqParams, err := requestParams(queryParams)
+	if err != nil {
+		log.Fatal(err)
+	}
+	inserted, updated, skipped, err := saveItems(resps, reqParams, writeItem)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *resumeFile != "" && !*dryRun {
+		if err = saveCheckpoint(*resumeFile, query, next); err != nil {
 			log.Fatal(err)
 		}
-		resps = r.ItemsResponse
-	default:
-		usage()
 	}
-	if len(resps) == 0 {
-		os.Exit(0)
+	if err = flushItems(); err != nil {
+		log.Fatal(err)
 	}
-	if len(resps[0].ErrorMessage) > 0 {
-		log.Fatal(resps[0].ErrorMessage)
+	printSummary(os.Stderr, runSummary{
+		operation:  flag.Arg(0),
+		query:      cmp.Or(queryParams["keywords"], queryParams["categoryId"]),
+		calls:      tr.calls,
+		fetched:    searchItemCount(resps),
+		duplicates: duplicateItems(resps),
+		inserted:   inserted,
+		updated:    updated,
+		skipped:    skipped,
+		elapsed:    time.Since(start),
+	})
+}
+
+// newFindingClient returns a client for the eBay Finding API that sends
+// requests with the app ID id through rt. If endpoint is not empty, requests
+// go to it instead of production. The endpoint may include a path and query,
+// as for a gateway, and request params are added to its query.
+func newFindingClient(rt http.RoundTripper, id, endpoint string) (*ebay.FindingClient, error) {
+	c := ebay.NewFindingClient(&http.Client{Timeout: time.Second * 10, Transport: rt}, id)
+	if endpoint == "" {
+		return c, nil
+	}
+	if u, err := url.Parse(endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint URL %q: must be an absolute URL", endpoint)
+	}
+	c.URL = endpoint
+	return c, nil
+}
+
+// appID returns the eBay application ID from EBAY_APP_ID, or else from the
+// file named by EBAY_APP_ID_FILE with trailing whitespace trimmed.
+func appID() (string, error) {
+	if id := os.Getenv("EBAY_APP_ID"); id != "" {
+		return id, nil
+	}
+	name := os.Getenv("EBAY_APP_ID_FILE")
+	if name == "" {
+		return "", nil
 	}
-	log.Print(resps)
-	db, err := sql.Open("postgres", os.Getenv("DB_URL"))
+	b, err := os.ReadFile(name)
 	if err != nil {
-		log.Fatalf("failed to connect to database: %v", err)
+		return "", fmt.Errorf("cannot read app ID: %w", err)
 	}
-	if err := insertItems(db, resps); err != nil {
-		log.Fatal(err)
+	return strings.TrimRightFunc(string(b), unicode.IsSpace), nil
+}
+
+// saveItems stores the items in rs, or with -dry-run converts them and logs
+// how many would be inserted without opening the database.
+func saveItems(rs []ebay.FindItemsResponse, reqParams string, out func(eBayItem) error) (inserted, updated, skipped int, err error) {
+	if !*dryRun {
+		return storeItems(rs, reqParams, out)
 	}
-	if err := db.Close(); err != nil {
-		log.Fatal(err)
+	var n int
+	skipped, err = eachItem(rs, reqParams, func(it eBayItem) error {
+		n++
+		return out(it)
+	})
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	log.Printf("dry run: %d items would be inserted", n)
+	return 0, 0, skipped, nil
+}
+
+// openDB opens the database given by DB_URL. It may be replaced to run
+// without a database.
+var openDB = func() (*sql.DB, error) {
+	return sql.Open("postgres", os.Getenv("DB_URL"))
+}
+
+// storeItems inserts the items in rs into the database given by DB_URL.
+func storeItems(rs []ebay.FindItemsResponse, reqParams string, out func(eBayItem) error) (inserted, updated, skipped int, err error) {
+	db, err := openDB()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	inserted, updated, skipped, err = insertItems(db, rs, reqParams, out)
+	if err != nil {
+		db.Close()
+		return 0, 0, 0, err
 	}
+	return inserted, updated, skipped, db.Close()
 }
 
+// logWarnings logs each distinct warning in rs, and each of the deprecation
+// notices eBay sent in response headers, once. Deprecation notices are called
+// out so operators know when an endpoint or parameter is being sunset.
+func logWarnings(rs []ebay.FindItemsResponse, deprecations []string) {
+	seen := make(map[string]bool)
+	warn := func(msg string, deprecation bool) {
+		if seen[msg] {
+			return
+		}
+		seen[msg] = true
+		if deprecation {
+			warnf("deprecation warning: %s", msg)
+		} else {
+			warnf("warning: %s", msg)
+		}
+	}
+	for _, r := range rs {
+		for _, m := range r.ErrorMessage {
+			for _, e := range m.Error {
+				if isWarning(e) {
+					msg := strings.Join(e.Message, " ")
+					warn(msg, isDeprecation(msg))
+				}
+			}
+		}
+	}
+	for _, msg := range deprecations {
+		warn(msg, true)
+	}
+}
+
+// isDeprecation reports whether s mentions deprecation.
+func isDeprecation(s string) bool {
+	return strings.Contains(strings.ToLower(s), "deprecat")
+}
+
+// logVersion logs the eBay API version used for rs. It warns when the
+// version differs from want, if set, or changes between responses, since
+// the shape of responses may have changed with it.
+func logVersion(rs []ebay.FindItemsResponse, want string) {
+	var seen string
+	for _, r := range rs {
+		v := first(r.Version)
+		if v == "" || v == seen {
+			continue
+		}
+		if seen == "" {
+			infof("eBay API version %s", v)
+		} else {
+			warnf("warning: eBay API version changed from %s to %s", seen, v)
+		}
+		if want != "" && v != want {
+			warnf("warning: eBay API version %s differs from expected version %s", v, want)
+		}
+		seen = v
+	}
+}
+
+// A requestError is an error from the ebay package for a request that
+// failed in the transport. The ebay package keeps only the text of the
+// transport's error, so the error itself is kept too, for errors.Is.
+type requestError struct {
+	err       error
+	transport error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() []error {
+	return []error{e.err, e.transport}
+}
+
+// An apiError is an error eBay reported in the errorMessage of a response.
+type apiError struct {
+	id        string // eBay errorId, for matching specific errors
+	category  string // Application, Request, or System
+	domain    string
+	subdomain string
+	severity  string
+	message   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("eBay error %s (%s): %s", e.id, e.severity, e.message)
+}
+
+// temporary reports whether the request that caused e may succeed if
+// retried. Errors in the ServiceError domain or subdomain are failures
+// within eBay, while those in the RequestError and Security domains are
+// caused by the request itself. Other errors are classified by category:
+// System errors are failures within eBay, while Application and Request
+// errors are caused by the request.
+func (e *apiError) temporary() bool {
+	for _, d := range []string{e.domain, e.subdomain} {
+		switch d {
+		case "ServiceError":
+			return true
+		case "RequestError", "Security":
+			return false
+		}
+	}
+	return e.category == "System"
+}
+
+// responseError returns an *apiError for the first error in r that is not a
+// warning, or nil if there is none.
+func responseError(r ebay.FindItemsResponse) error {
+	for _, m := range r.ErrorMessage {
+		for _, e := range m.Error {
+			if !isWarning(e) {
+				return &apiError{
+					id:        first(e.ErrorID),
+					category:  first(e.Category),
+					domain:    first(e.Domain),
+					subdomain: first(e.Subdomain),
+					severity:  first(e.Severity),
+					message:   strings.Join(e.Message, " "),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// highestSeverity returns the most severe error severity in r, or nil if r
+// has no errors.
+func highestSeverity(r ebay.FindItemsResponse) *string {
+	var severity *string
+	for _, m := range r.ErrorMessage {
+		for _, e := range m.Error {
+			if len(e.Severity) == 0 {
+				continue
+			}
+			if severity == nil || e.Severity[0] == "Error" {
+				severity = &e.Severity[0]
+			}
+		}
+	}
+	return severity
+}
+
+func isWarning(e ebay.ErrorData) bool {
+	return len(e.Severity) > 0 && e.Severity[0] == "Warning"
+}
+
+// loadParams parses and validates the params string ps for the operation op.
+func loadParams(op, ps string) (map[string]string, error) {
+	o, ok := operations[op]
+	if !ok {
+		return nil, fmt.Errorf("unknown operation %q", op)
+	}
+	params, err := parseParams(ps)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range o.preset {
+		if _, ok := params[k]; !ok {
+			params[k] = v
+		}
+	}
+	expandItemFilterValues(params)
+	if err = normalizeConditions(params); err != nil {
+		return nil, err
+	}
+	if err = applySiteID(params); err != nil {
+		return nil, err
+	}
+	if err = applyKeywordsMode(params, *keywordsMode); err != nil {
+		return nil, err
+	}
+	if err = validateParams(op, params); err != nil {
+		return nil, err
+	}
+	for _, check := range []func(map[string]string) error{checkSiteCurrency, checkSitePostalCode, checkExcludeCategories} {
+		if err = check(params); err != nil {
+			if *strict {
+				return nil, err
+			}
+			warnf("%v", err)
+		}
+	}
+	if err = applyDistanceUnit(params, *distUnit); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// parseParams parses params of the form k1=v1&k2=v2. Keys and values may be
+// percent-encoded, and values may contain “=”. A “+” is kept as is rather
+// than decoded as a space, since eBay keywords use it literally.
 func parseParams(ps string) (map[string]string, error) {
 	params := make(map[string]string)
 	for _, p := range strings.Split(ps, "&") {
-		parts := strings.Split(p, "=")
-		if len(parts) != 2 {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok {
 			return nil, fmt.Errorf("invalid parameter %q", p)
 		}
-		params[parts[0]] = parts[1]
+		k, err := url.PathUnescape(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameter %q: %w", p, err)
+		}
+		v, err = url.PathUnescape(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameter %q: %w", p, err)
+		}
+		params[k] = v
 	}
 	return params, nil
 }
 
+// expandItemFilterValues expands comma-separated values of item filters that
+// accept several values, as in itemFilter.value=1,2,3, into numbered values
+// itemFilter.value(0)=1, itemFilter.value(1)=2, and itemFilter.value(2)=3.
+// Values of other item filters, such as MaxPrice, are left as given.
+func expandItemFilterValues(params map[string]string) {
+	for k, v := range params {
+		if !strings.HasPrefix(k, "itemFilter") || !strings.HasSuffix(k, ".value") || !strings.Contains(v, ",") {
+			continue
+		}
+		if !slices.Contains(multiValueItemFilters, params[strings.TrimSuffix(k, "value")+"name"]) {
+			continue
+		}
+		delete(params, k)
+		for i, s := range strings.Split(v, ",") {
+			params[fmt.Sprintf("%s(%d)", k, i)] = s
+		}
+	}
+}
+
 type eBayItem struct {
 	timestamp                                  time.Time
+	ingestedAt                                 time.Time
 	version                                    string
+	ack                                        *string
+	severity                                   *string
+	requestParams                              string
 	conditionDisplayName                       string
 	conditionID                                int
 	country                                    string
+	distanceUnit                               *string
+	distanceValue                              *float64
+	expeditedShipping                          *bool
 	galleryURL                                 *string
 	globalID                                   string
+	handlingTime                               *int
 	isMultiVariationListing                    bool
 	itemID                                     int64
 	listingInfoBestOfferEnabled                bool
@@ -143,11 +988,14 @@ type eBayItem struct {
 	listingInfoStartTime                       time.Time
 	listingInfoWatchCount                      *int
 	location                                   *string
+	oneDayShippingAvailable                    *bool
+	paymentMethods                             []string
 	postalCode                                 *string
-	primaryCategoryID                          int
+	primaryCategoryID                          int64
 	primaryCategoryName                        string
 	productIDType                              *string
-	productIDValue                             *int64
+	productIDValue                             *string
+	sellingStatusBidCount                      *int
 	sellingStatusConvertedCurrentPriceCurrency *string
 	sellingStatusConvertedCurrentPriceValue    *float64
 	sellingStatusCurrentPriceCurrency          *string
@@ -160,88 +1008,400 @@ type eBayItem struct {
 	shipToLocations                            *string
 	subtitle                                   *string
 	title                                      string
+	titleNormalized                            *string
 	topRatedListing                            bool
+	totalCost                                  *float64
 	viewItemURL                                *string
 }
 
-func insertItems(db *sql.DB, rs []ebay.FindItemsResponse) error {
-	var eBayItems []eBayItem
-	for _, r := range rs {
-		items, err := responseToItems(r)
-		if err != nil {
-			log.Printf("failed to convert eBay API response to items: %v", err)
-			continue
-		}
-		eBayItems = append(eBayItems, items...)
-	}
+// itemColumns lists the item table columns and the eBayItem values stored in
+// them, in the order they are copied.
+var itemColumns = []struct {
+	name  string
+	value func(it *eBayItem) any
+}{
+	{"timestamp", func(it *eBayItem) any { return it.timestamp }},
+	{"ingested_at", func(it *eBayItem) any { return it.ingestedAt }},
+	{"version", func(it *eBayItem) any { return it.version }},
+	{"ack", func(it *eBayItem) any { return it.ack }},
+	{"severity", func(it *eBayItem) any { return it.severity }},
+	{"request_params", func(it *eBayItem) any { return it.requestParams }},
+	{"condition_display_name", func(it *eBayItem) any { return it.conditionDisplayName }},
+	{"condition_id", func(it *eBayItem) any { return it.conditionID }},
+	{"country", func(it *eBayItem) any { return it.country }},
+	{"distance_unit", func(it *eBayItem) any { return it.distanceUnit }},
+	{"distance_value", func(it *eBayItem) any { return it.distanceValue }},
+	{"expedited_shipping", func(it *eBayItem) any { return it.expeditedShipping }},
+	{"gallery_url", func(it *eBayItem) any { return it.galleryURL }},
+	{"global_id", func(it *eBayItem) any { return it.globalID }},
+	{"handling_time", func(it *eBayItem) any { return it.handlingTime }},
+	{"is_multi_variation_listing", func(it *eBayItem) any { return it.isMultiVariationListing }},
+	{"item_id", func(it *eBayItem) any { return it.itemID }},
+	{"listing_info_best_offer_enabled", func(it *eBayItem) any { return it.listingInfoBestOfferEnabled }},
+	{"listing_info_buy_it_now_available", func(it *eBayItem) any { return it.listingInfoBuyItNowAvailable }},
+	{"listing_info_end_time", func(it *eBayItem) any { return it.listingInfoEndTime }},
+	{"listing_info_listing_type", func(it *eBayItem) any { return it.listingInfoListingType }},
+	{"listing_info_start_time", func(it *eBayItem) any { return it.listingInfoStartTime }},
+	{"listing_info_watch_count", func(it *eBayItem) any { return it.listingInfoWatchCount }},
+	{"location", func(it *eBayItem) any { return it.location }},
+	{"one_day_shipping_available", func(it *eBayItem) any { return it.oneDayShippingAvailable }},
+	{"payment_methods", func(it *eBayItem) any { return it.paymentMethods }},
+	{"postal_code", func(it *eBayItem) any { return it.postalCode }},
+	{"primary_category_id", func(it *eBayItem) any { return it.primaryCategoryID }},
+	{"primary_category_name", func(it *eBayItem) any { return it.primaryCategoryName }},
+	{"product_id_type", func(it *eBayItem) any { return it.productIDType }},
+	{"product_id_value", func(it *eBayItem) any { return it.productIDValue }},
+	{"selling_status_bid_count", func(it *eBayItem) any { return it.sellingStatusBidCount }},
+	{"selling_status_converted_current_price_currency", func(it *eBayItem) any { return it.sellingStatusConvertedCurrentPriceCurrency }},
+	{"selling_status_converted_current_price_value", func(it *eBayItem) any { return it.sellingStatusConvertedCurrentPriceValue }},
+	{"selling_status_current_price_currency", func(it *eBayItem) any { return it.sellingStatusCurrentPriceCurrency }},
+	{"selling_status_current_price_value", func(it *eBayItem) any { return it.sellingStatusCurrentPriceValue }},
+	{"selling_status_selling_state", func(it *eBayItem) any { return it.sellingStatusSellingState }},
+	{"selling_status_time_left", func(it *eBayItem) any { return it.sellingStatusTimeLeft }},
+	{"shipping_service_cost_currency", func(it *eBayItem) any { return it.shippingServiceCostCurrency }},
+	{"shipping_service_cost_value", func(it *eBayItem) any { return it.shippingServiceCostValue }},
+	{"shipping_type", func(it *eBayItem) any { return it.shippingType }},
+	{"ship_to_locations", func(it *eBayItem) any { return it.shipToLocations }},
+	{"subtitle", func(it *eBayItem) any { return it.subtitle }},
+	{"title", func(it *eBayItem) any { return it.title }},
+	{"title_normalized", func(it *eBayItem) any { return it.titleNormalized }},
+	{"top_rated_listing", func(it *eBayItem) any { return it.topRatedListing }},
+	{"total_cost", func(it *eBayItem) any { return it.totalCost }},
+	{"view_item_url", func(it *eBayItem) any { return it.viewItemURL }},
+}
+
+// insertItems converts the items in rs and copies them into the item table
+// as they are converted, passing each to out first. With -upsert, the items
+// are staged in a temporary table and merged into the item table, replacing
+// rows with the same item ID, and with -change-log the changes are recorded
+// first. It returns the number of items inserted, the
+// number of stored items updated, and the number skipped because they failed
+// to convert.
+func insertItems(db *sql.DB, rs []ebay.FindItemsResponse, reqParams string, out func(eBayItem) error) (inserted, updated, skipped int, err error) {
 	txn, err := db.Begin()
 	if err != nil {
-		return err
-	}
-	stmt, err := txn.Prepare(pq.CopyIn(
-		"item", "timestamp", "version", "condition_display_name",
-		"condition_id", "country", "gallery_url", "global_id",
-		"is_multi_variation_listing", "item_id",
-		"listing_info_best_offer_enabled", "listing_info_buy_it_now_available",
-		"listing_info_end_time", "listing_info_listing_type",
-		"listing_info_start_time", "listing_info_watch_count", "location",
-		"postal_code", "primary_category_id", "primary_category_name",
-		"product_id_type", "product_id_value",
-		"selling_status_converted_current_price_currency",
-		"selling_status_converted_current_price_value",
-		"selling_status_current_price_currency",
-		"selling_status_current_price_value", "selling_status_selling_state",
-		"selling_status_time_left", "shipping_service_cost_currency",
-		"shipping_service_cost_value", "shipping_type", "ship_to_locations",
-		"subtitle", "title", "top_rated_listing", "view_item_url"))
+		return 0, 0, 0, err
+	}
+	// Rolling back a committed transaction does nothing.
+	defer txn.Rollback()
+	names := copyColumnNames()
+	table := "item"
+	if *upsert {
+		table = "item_upsert"
+		q := "CREATE TEMP TABLE item_upsert ON COMMIT DROP AS SELECT " + quoteColumns(names) + " FROM item WITH NO DATA"
+		if _, err = txn.Exec(q); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	stmt, err := txn.Prepare(pq.CopyIn(table, names...))
 	if err != nil {
-		return err
-	}
-	for _, it := range eBayItems {
-		_, err = stmt.Exec(
-			it.timestamp, it.version, it.conditionDisplayName, it.conditionID,
-			it.country, it.galleryURL, it.globalID, it.isMultiVariationListing,
-			it.itemID, it.listingInfoBestOfferEnabled,
-			it.listingInfoBuyItNowAvailable, it.listingInfoEndTime,
-			it.listingInfoListingType, it.listingInfoStartTime,
-			it.listingInfoWatchCount, it.location, it.postalCode,
-			it.primaryCategoryID, it.primaryCategoryName, it.productIDType,
-			it.productIDValue, it.sellingStatusConvertedCurrentPriceCurrency,
-			it.sellingStatusConvertedCurrentPriceValue,
-			it.sellingStatusCurrentPriceCurrency,
-			it.sellingStatusCurrentPriceValue, it.sellingStatusSellingState,
-			it.sellingStatusTimeLeft, it.shippingServiceCostCurrency,
-			it.shippingServiceCostValue, it.shippingType, it.shipToLocations,
-			it.subtitle, it.title, it.topRatedListing, it.viewItemURL,
-		)
-		if err != nil {
+		return 0, 0, 0, err
+	}
+	skipped, err = eachItem(rs, reqParams, func(it eBayItem) error {
+		if err := out(it); err != nil {
+			return err
+		}
+		values := make([]any, len(itemColumns))
+		for i, c := range itemColumns {
+			values[i] = c.value(&it)
+			if ss, ok := values[i].([]string); ok {
+				values[i] = pq.Array(ss)
+			}
+		}
+		if _, err := stmt.Exec(values...); err != nil {
 			return err
 		}
+		inserted++
+		return nil
+	})
+	if err != nil {
+		return 0, 0, 0, err
 	}
 	if _, err = stmt.Exec(); err != nil {
-		return err
+		return 0, 0, 0, err
 	}
 	if err = stmt.Close(); err != nil {
-		return err
+		return 0, 0, 0, err
+	}
+	if *upsert {
+		if *changeLog {
+			if err = logChanges(txn); err != nil {
+				return 0, 0, 0, err
+			}
+		}
+		inserted, updated, err = mergeItems(txn, names)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	return inserted, updated, skipped, txn.Commit()
+}
+
+// mergeItems merges the rows staged in item_upsert into the item table,
+// returning the number of rows inserted and the number of stored rows
+// updated.
+func mergeItems(txn *sql.Tx, names []string) (inserted, updated int, err error) {
+	rows, err := txn.Query(upsertQuery(names))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var isNew bool
+		if err = rows.Scan(&isNew); err != nil {
+			return 0, 0, err
+		}
+		if isNew {
+			inserted++
+		} else {
+			updated++
+		}
+	}
+	return inserted, updated, rows.Err()
+}
+
+// upsertQuery returns the statement that merges the rows staged in
+// item_upsert into the item table, updating the columns of an item already
+// stored. If an item was staged more than once, its latest row is kept. The
+// statement returns whether each row was inserted rather than updated, which
+// is when its xmax system column is 0.
+func upsertQuery(names []string) string {
+	cols := quoteColumns(names)
+	itemID, timestamp := pq.QuoteIdentifier(columnName("item_id")), pq.QuoteIdentifier(columnName("timestamp"))
+	var set []string
+	for _, n := range names {
+		if n != columnName("item_id") {
+			q := pq.QuoteIdentifier(n)
+			set = append(set, q+" = EXCLUDED."+q)
+		}
+	}
+	return "INSERT INTO item (" + cols + ") SELECT DISTINCT ON (" + itemID + ") " + cols +
+		" FROM item_upsert ORDER BY " + itemID + ", " + timestamp + " DESC" +
+		" ON CONFLICT (" + itemID + ") DO UPDATE SET " + strings.Join(set, ", ") +
+		" RETURNING xmax = 0"
+}
+
+// logChanges records in the item_changes table the price, watch count, and
+// selling state of each stored item that the rows staged in item_upsert
+// change. It must run before the rows are merged, while the stored values
+// remain.
+func logChanges(txn *sql.Tx) error {
+	res, err := txn.Exec(changeLogQuery())
+	if err != nil {
+		return fmt.Errorf("cannot record item changes: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		infof("recorded %d item changes", n)
+	}
+	return nil
+}
+
+// changeLogQuery returns the statement that records in item_changes the
+// stored and staged values of each item whose price, watch count, or selling
+// state the rows staged in item_upsert change. As when merging, the latest
+// staged row of an item is used.
+func changeLogQuery() string {
+	col := func(name string) string { return pq.QuoteIdentifier(columnName(name)) }
+	itemID, timestamp := col("item_id"), col("timestamp")
+	tracked := []string{col("selling_status_current_price_value"), col("listing_info_watch_count"), col("selling_status_selling_state")}
+	var values, stored, staged []string
+	for _, c := range tracked {
+		values = append(values, "i."+c, "u."+c)
+		stored = append(stored, "i."+c)
+		staged = append(staged, "u."+c)
 	}
-	return txn.Commit()
+	return "INSERT INTO item_changes (item_id, changed_at, old_price, new_price, old_watch_count, new_watch_count, old_selling_state, new_selling_state)" +
+		" SELECT i." + itemID + ", u." + timestamp + ", " + strings.Join(values, ", ") +
+		" FROM item i JOIN (SELECT DISTINCT ON (" + itemID + ") * FROM item_upsert ORDER BY " + itemID + ", " + timestamp + " DESC) u" +
+		" ON u." + itemID + " = i." + itemID +
+		" WHERE (" + strings.Join(stored, ", ") + ") IS DISTINCT FROM (" + strings.Join(staged, ", ") + ")"
 }
 
-func responseToItems(resp ebay.FindItemsResponse) ([]eBayItem, error) {
-	items := make([]eBayItem, len(resp.SearchResult[0].Item))
-	for i := range items {
-		it, err := item(resp.SearchResult[0].Item[i])
+// requestParams returns params encoded as JSON with the eBay application ID
+// redacted, so the request that produced a batch can be reproduced later.
+func requestParams(params map[string]string) (string, error) {
+	redacted := make(map[string]string, len(params))
+	for k, v := range params {
+		if strings.EqualFold(k, "Security-AppName") {
+			continue
+		}
+		redacted[k] = v
+	}
+	b, err := json.Marshal(redacted)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode request params: %w", err)
+	}
+	return string(b), nil
+}
+
+// searchItemCount returns the number of items eBay returned in rs.
+func searchItemCount(rs []ebay.FindItemsResponse) int {
+	var n int
+	for _, r := range rs {
+		if len(r.SearchResult) > 0 {
+			n += len(r.SearchResult[0].Item)
+		}
+	}
+	return n
+}
+
+// eachItem converts the items in rs, recording reqParams as the request that
+// produced them, and calls fn with each item as it is converted. Items
+// outside -ending-within are left out. It returns the number of items
+// skipped because they failed to convert.
+func eachItem(rs []ebay.FindItemsResponse, reqParams string, fn func(eBayItem) error) (int, error) {
+	var skipped int
+	ingestedAt := now().UTC()
+	endBefore := ingestedAt.Add(*endingWithin)
+	for _, r := range rs {
+		failed, err := responseToItems(r, func(it eBayItem) error {
+			if *endingWithin > 0 && !it.listingInfoEndTime.Before(endBefore) {
+				return nil
+			}
+			if *textPolicy == "empty" {
+				emptyOptionalText(&it)
+			}
+			it.requestParams = reqParams
+			it.ingestedAt = ingestedAt
+			if *tsSource == "ingest" {
+				it.timestamp = ingestedAt
+			}
+			return fn(it)
+		})
+		skipped += failed
 		if err != nil {
-			return nil, err
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+var errErrorRateExceeded = errors.New("item conversion error rate exceeded")
+
+// responseToItems converts the items in resp, calling fn with each item as
+// it is converted and skipping items that fail to convert. It returns the
+// number of items skipped, and an error from fn or an error wrapping
+// errErrorRateExceeded if the fraction of items that failed exceeds
+// -max-error-rate.
+func responseToItems(resp ebay.FindItemsResponse, fn func(eBayItem) error) (int, error) {
+	if len(resp.SearchResult) == 0 {
+		return 0, nil
+	}
+	if len(resp.Timestamp) == 0 || len(resp.Version) == 0 {
+		return 0, fmt.Errorf("%w: response lacks timestamp or version", errMissingField)
+	}
+	searchItems := resp.SearchResult[0].Item
+	if n, err := strconv.Atoi(resp.SearchResult[0].Count); err == nil && n != len(searchItems) {
+		warnf("eBay reported %d items but returned %d", n, len(searchItems))
+	}
+	severity := highestSeverity(resp)
+	var failed, dropped int
+	for i := range searchItems {
+		if *requireImage && len(searchItems[i].GalleryURL) == 0 && len(searchItems[i].PictureURLLarge) == 0 {
+			dropped++
+			continue
+		}
+		it, err := item(searchItems[i])
+		if err != nil {
+			warnf("failed to convert eBay item: %v", err)
+			failed++
+			continue
 		}
 		it.timestamp = resp.Timestamp[0]
 		it.version = resp.Version[0]
-		items[i] = it
+		it.ack = firstElem(resp.Ack)
+		it.severity = severity
+		rows := []eBayItem{it}
+		if *flatShipping {
+			rows, err = shippingRows(it, searchItems[i].ShippingInfo)
+			if err != nil {
+				warnf("failed to convert eBay item: %v", err)
+				failed++
+				continue
+			}
+		}
+		for _, row := range rows {
+			if err = fn(row); err != nil {
+				return failed, err
+			}
+		}
 	}
-	return items, nil
+	if dropped > 0 {
+		warnf("dropped %d items without images", dropped)
+	}
+	if n := len(searchItems); n > 0 && float64(failed)/float64(n) > *maxErrorRate {
+		return failed, fmt.Errorf("%w: %d of %d items failed", errErrorRateExceeded, failed, n)
+	}
+	return failed, nil
+}
+
+// shippingRows returns a copy of it for each shipping service cost in
+// shipping, or it alone if there is at most one.
+func shippingRows(it eBayItem, shipping []ebay.ShippingInfo) ([]eBayItem, error) {
+	if len(shipping) == 0 || len(shipping[0].ShippingServiceCost) < 2 {
+		return []eBayItem{it}, nil
+	}
+	costs := shipping[0].ShippingServiceCost
+	rows := make([]eBayItem, len(costs))
+	for i := range costs {
+		v, err := strconv.ParseFloat(costs[i].Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert shipping service cost value to float64: %w", err)
+		}
+		rows[i] = it
+		rows[i].shippingServiceCostCurrency = &costs[i].CurrencyID
+		rows[i].shippingServiceCostValue = &v
+		if *storeTotal {
+			rows[i].totalCost = totalCost(rows[i])
+		}
+	}
+	return rows, nil
+}
+
+// maxTitleLen is the maximum length of an eBay listing title.
+const maxTitleLen = 80
+
+var errMissingField = errors.New("missing required field")
+
+// missingFields returns the names of the fields item requires that it
+// lacks. The item table cannot store an item without them.
+func missingFields(it ebay.SearchItem) []string {
+	var missing []string
+	check := func(name string, n int) bool {
+		if n == 0 {
+			missing = append(missing, name)
+		}
+		return n > 0
+	}
+	if check("condition", len(it.Condition)) {
+		check("condition.conditionDisplayName", len(it.Condition[0].ConditionDisplayName))
+		check("condition.conditionId", len(it.Condition[0].ConditionID))
+	}
+	check("country", len(it.Country))
+	check("globalId", len(it.GlobalID))
+	check("isMultiVariationListing", len(it.IsMultiVariationListing))
+	check("itemId", len(it.ItemID))
+	if check("listingInfo", len(it.ListingInfo)) {
+		li := it.ListingInfo[0]
+		check("listingInfo.bestOfferEnabled", len(li.BestOfferEnabled))
+		check("listingInfo.buyItNowAvailable", len(li.BuyItNowAvailable))
+		check("listingInfo.endTime", len(li.EndTime))
+		check("listingInfo.listingType", len(li.ListingType))
+		check("listingInfo.startTime", len(li.StartTime))
+	}
+	if check("primaryCategory", len(it.PrimaryCategory)) {
+		check("primaryCategory.categoryId", len(it.PrimaryCategory[0].CategoryID))
+		check("primaryCategory.categoryName", len(it.PrimaryCategory[0].CategoryName))
+	}
+	check("title", len(it.Title))
+	check("topRatedListing", len(it.TopRatedListing))
+	return missing
 }
 
 func item(it ebay.SearchItem) (eBayItem, error) {
+	if missing := missingFields(it); len(missing) > 0 {
+		return eBayItem{}, fmt.Errorf("%w: item %s lacks %s", errMissingField, cmp.Or(first(it.ItemID), "with no ID"), strings.Join(missing, ", "))
+	}
 	conditionID, err := strconv.Atoi(it.Condition[0].ConditionID[0])
 	if err != nil {
 		return eBayItem{}, fmt.Errorf("cannot convert conditionID to int: %w", err)
@@ -252,7 +1412,8 @@ func item(it ebay.SearchItem) (eBayItem, error) {
 	}
 	itemID, err := strconv.ParseInt(it.ItemID[0], 10, 64)
 	if err != nil {
-		return eBayItem{}, fmt.Errorf("cannot convert itemID to int64: %w", err)
+		// Name the offending ID rather than repeat strconv's description.
+		return eBayItem{}, fmt.Errorf("invalid itemID %q: %w", it.ItemID[0], errors.Unwrap(err))
 	}
 	bestOfferEnabled, err := strconv.ParseBool(it.ListingInfo[0].BestOfferEnabled[0])
 	if err != nil {
@@ -262,6 +1423,22 @@ func item(it ebay.SearchItem) (eBayItem, error) {
 	if err != nil {
 		return eBayItem{}, fmt.Errorf("cannot convert buyItNowAvailable to bool: %w", err)
 	}
+	var distanceUnit *string
+	var distanceValue *float64
+	if len(it.Distance) > 0 {
+		var v float64
+		v, err = strconv.ParseFloat(it.Distance[0].Value, 64)
+		if err != nil {
+			return eBayItem{}, fmt.Errorf("cannot convert distance value to float64: %w", err)
+		}
+		unit := it.Distance[0].Unit
+		if unit != *distUnit {
+			v = convertDistance(v, unit, *distUnit)
+			unit = *distUnit
+		}
+		distanceUnit = &unit
+		distanceValue = &v
+	}
 	var watchCount *int
 	if len(it.ListingInfo[0].WatchCount) > 0 {
 		var v int
@@ -271,66 +1448,127 @@ func item(it ebay.SearchItem) (eBayItem, error) {
 		}
 		watchCount = &v
 	}
-	primaryCategoryID, err := strconv.Atoi(it.PrimaryCategory[0].CategoryID[0])
+	primaryCategoryID, err := strconv.ParseInt(it.PrimaryCategory[0].CategoryID[0], 10, 64)
 	if err != nil {
-		return eBayItem{}, fmt.Errorf("cannot convert primaryCategoryID to int: %w", err)
+		return eBayItem{}, fmt.Errorf("cannot convert primaryCategoryID to int64: %w", err)
 	}
-	var productIDType *string
-	var productIDValue *int64
+	var productIDType, productIDValue *string
 	if len(it.ProductID) > 0 {
 		productIDType = &it.ProductID[0].Type
-		var v int64
-		v, err = strconv.ParseInt(it.ProductID[0].Value, 10, 64)
+		productIDValue = &it.ProductID[0].Value
+	}
+	var sellingStatus ebay.SellingStatus
+	if len(it.SellingStatus) > 0 {
+		sellingStatus = it.SellingStatus[0]
+	}
+	var sellingStatusBidCount *int
+	if len(sellingStatus.BidCount) > 0 {
+		var v int
+		v, err = strconv.Atoi(sellingStatus.BidCount[0])
 		if err != nil {
-			return eBayItem{}, fmt.Errorf("cannot convert productID value to int64: %w", err)
+			return eBayItem{}, fmt.Errorf("cannot convert bidCount to int: %w", err)
 		}
-		productIDValue = &v
+		sellingStatusBidCount = &v
 	}
 	var sellingStatusSellingState, sellingStatusTimeLeft *string
-	if len(it.SellingStatus[0].SellingState) > 0 {
-		sellingStatusSellingState = &it.SellingStatus[0].SellingState[0]
-		sellingStatusTimeLeft = &it.SellingStatus[0].TimeLeft[0]
+	if len(sellingStatus.SellingState) > 0 {
+		sellingStatusSellingState = &sellingStatus.SellingState[0]
+		sellingStatusTimeLeft = firstElem(sellingStatus.TimeLeft)
 	}
 	var sellingStatusPriceCurrency, sellingStatusConvertedPriceCurrency *string
 	var sellingStatusPriceValue, sellingStatusConvertedPriceValue *float64
-	if len(it.SellingStatus[0].CurrentPrice) > 0 {
-		sellingStatusPriceCurrency = &it.SellingStatus[0].CurrentPrice[0].CurrencyID
+	if len(sellingStatus.CurrentPrice) > 0 {
+		sellingStatusPriceCurrency = &sellingStatus.CurrentPrice[0].CurrencyID
 		var v float64
-		v, err = strconv.ParseFloat(it.SellingStatus[0].CurrentPrice[0].Value, 64)
+		v, err = strconv.ParseFloat(sellingStatus.CurrentPrice[0].Value, 64)
 		if err != nil {
 			return eBayItem{}, fmt.Errorf("cannot convert selling status current price value to float64: %w", err)
 		}
 		sellingStatusPriceValue = &v
-		sellingStatusConvertedPriceCurrency = &it.SellingStatus[0].ConvertedCurrentPrice[0].CurrencyID
-		v, err = strconv.ParseFloat(it.SellingStatus[0].ConvertedCurrentPrice[0].Value, 64)
+	}
+	if len(sellingStatus.ConvertedCurrentPrice) > 0 {
+		sellingStatusConvertedPriceCurrency = &sellingStatus.ConvertedCurrentPrice[0].CurrencyID
+		var v float64
+		v, err = strconv.ParseFloat(sellingStatus.ConvertedCurrentPrice[0].Value, 64)
 		if err != nil {
 			return eBayItem{}, fmt.Errorf("cannot convert selling status converted current price value to float64: %w", err)
 		}
 		sellingStatusConvertedPriceValue = &v
 	}
+	var shipping ebay.ShippingInfo
+	if len(it.ShippingInfo) > 0 {
+		shipping = it.ShippingInfo[0]
+	}
 	var shippingServiceCurrency, shippingType, shipToLocations *string
 	var shippingServiceValue *float64
-	if len(it.ShippingInfo[0].ShippingServiceCost) > 0 {
-		shippingServiceCurrency = &it.ShippingInfo[0].ShippingServiceCost[0].CurrencyID
+	if len(shipping.ShippingServiceCost) > 0 {
+		shippingServiceCurrency = &shipping.ShippingServiceCost[0].CurrencyID
 		var v float64
-		v, err = strconv.ParseFloat(it.ShippingInfo[0].ShippingServiceCost[0].Value, 64)
+		v, err = strconv.ParseFloat(shipping.ShippingServiceCost[0].Value, 64)
 		if err != nil {
 			return eBayItem{}, fmt.Errorf("cannot convert shipping service cost value to float64: %w", err)
 		}
 		shippingServiceValue = &v
-		shippingType = &it.ShippingInfo[0].ShippingType[0]
-		shipToLocations = &it.ShippingInfo[0].ShipToLocations[0]
+		shippingType = firstElem(shipping.ShippingType)
+		shipToLocations = firstElem(shipping.ShipToLocations)
+	}
+	var handlingTime *int
+	if len(shipping.HandlingTime) > 0 {
+		var v int
+		v, err = strconv.Atoi(shipping.HandlingTime[0])
+		if err != nil {
+			return eBayItem{}, fmt.Errorf("cannot convert handlingTime to int: %w", err)
+		}
+		handlingTime = &v
+	}
+	var expeditedShipping, oneDayShippingAvailable *bool
+	if len(shipping.ExpeditedShipping) > 0 {
+		var v bool
+		v, err = strconv.ParseBool(shipping.ExpeditedShipping[0])
+		if err != nil {
+			return eBayItem{}, fmt.Errorf("cannot convert expeditedShipping to bool: %w", err)
+		}
+		expeditedShipping = &v
+	}
+	if len(shipping.OneDayShippingAvailable) > 0 {
+		var v bool
+		v, err = strconv.ParseBool(shipping.OneDayShippingAvailable[0])
+		if err != nil {
+			return eBayItem{}, fmt.Errorf("cannot convert oneDayShippingAvailable to bool: %w", err)
+		}
+		oneDayShippingAvailable = &v
 	}
 	topRatedListing, err := strconv.ParseBool(it.TopRatedListing[0])
 	if err != nil {
 		return eBayItem{}, fmt.Errorf("cannot convert topRatedListing to bool: %w", err)
 	}
-	return eBayItem{
+	if n := utf8.RuneCountInString(it.Title[0]); n > maxTitleLen {
+		warnf("item %d has an unusually long title (%d characters)", itemID, n)
+	}
+	var titleNormalized *string
+	if *normTitle {
+		t := strings.ToLower(strings.Join(strings.Fields(it.Title[0]), " "))
+		titleNormalized = &t
+	}
+	viewItemURL := firstElem(it.ViewItemURL)
+	if viewItemURL != nil && affiliateParams != nil {
+		var u string
+		u, err = addAffiliateParams(*viewItemURL)
+		if err != nil {
+			return eBayItem{}, fmt.Errorf("cannot add affiliate params to viewItemURL: %w", err)
+		}
+		viewItemURL = &u
+	}
+	converted := eBayItem{
 		conditionDisplayName:         it.Condition[0].ConditionDisplayName[0],
 		conditionID:                  conditionID,
 		country:                      it.Country[0],
+		distanceUnit:                 distanceUnit,
+		distanceValue:                distanceValue,
+		expeditedShipping:            expeditedShipping,
 		galleryURL:                   firstElem(it.GalleryURL),
 		globalID:                     it.GlobalID[0],
+		handlingTime:                 handlingTime,
 		isMultiVariationListing:      isMultiVariationListing,
 		itemID:                       itemID,
 		listingInfoBestOfferEnabled:  bestOfferEnabled,
@@ -340,11 +1578,14 @@ func item(it ebay.SearchItem) (eBayItem, error) {
 		listingInfoStartTime:         it.ListingInfo[0].StartTime[0],
 		listingInfoWatchCount:        watchCount,
 		location:                     firstElem(it.Location),
+		oneDayShippingAvailable:      oneDayShippingAvailable,
+		paymentMethods:               normalizePaymentMethods(itemID, it.PaymentMethod),
 		postalCode:                   firstElem(it.PostalCode),
 		primaryCategoryID:            primaryCategoryID,
 		primaryCategoryName:          it.PrimaryCategory[0].CategoryName[0],
 		productIDType:                productIDType,
 		productIDValue:               productIDValue,
+		sellingStatusBidCount:        sellingStatusBidCount,
 		sellingStatusConvertedCurrentPriceCurrency: sellingStatusConvertedPriceCurrency,
 		sellingStatusConvertedCurrentPriceValue:    sellingStatusConvertedPriceValue,
 		sellingStatusCurrentPriceCurrency:          sellingStatusPriceCurrency,
@@ -357,9 +1598,79 @@ func item(it ebay.SearchItem) (eBayItem, error) {
 		shipToLocations:                            shipToLocations,
 		subtitle:                                   firstElem(it.Subtitle),
 		title:                                      it.Title[0],
+		titleNormalized:                            titleNormalized,
 		topRatedListing:                            topRatedListing,
-		viewItemURL:                                firstElem(it.ViewItemURL),
-	}, nil
+		viewItemURL:                                viewItemURL,
+	}
+	if *storeTotal {
+		converted.totalCost = totalCost(converted)
+	}
+	return converted, nil
+}
+
+// totalCost returns the current price of it plus its shipping service cost,
+// or nil if either is absent or they are in different currencies.
+func totalCost(it eBayItem) *float64 {
+	if it.sellingStatusCurrentPriceValue == nil || it.shippingServiceCostValue == nil ||
+		*it.sellingStatusCurrentPriceCurrency != *it.shippingServiceCostCurrency {
+		return nil
+	}
+	v := *it.sellingStatusCurrentPriceValue + *it.shippingServiceCostValue
+	return &v
+}
+
+// paymentMethods maps lowercased eBay payment methods to their documented
+// spelling.
+var paymentMethods = make(map[string]string)
+
+func init() {
+	for _, m := range []string{
+		"AmEx", "CashInPerson", "CashOnPickup", "CCAccepted", "COD",
+		"CreditCard", "Diners", "DirectDebit", "Discover", "ELV", "Escrow",
+		"IntegratedMerchantCreditCard", "LoanCheck", "MOCC",
+		"MoneyXferAccepted", "MoneyXferAcceptedInCheckout", "None", "Other",
+		"OtherOnlinePayments", "PaisaPay", "PaisaPayEMI", "PaymentSeeDescription",
+		"PayOnPickup", "PayPal", "PayPalCredit", "PayUponInvoice",
+		"PersonalCheck", "VisaMC",
+	} {
+		paymentMethods[strings.ToLower(m)] = m
+	}
+}
+
+// normalizePaymentMethods returns methods with known payment methods in
+// their documented spelling. Unknown methods are logged and kept as is.
+func normalizePaymentMethods(itemID int64, methods []string) []string {
+	if len(methods) == 0 {
+		return nil
+	}
+	normalized := make([]string, len(methods))
+	for i, m := range methods {
+		if known, ok := paymentMethods[strings.ToLower(m)]; ok {
+			normalized[i] = known
+			continue
+		}
+		warnf("item %d has unknown payment method %q", itemID, m)
+		normalized[i] = m
+	}
+	return normalized
+}
+
+// emptyOptionalText sets the absent optional text fields of it that eBay
+// returns to the empty string, so they are stored as empty strings rather
+// than NULL. Fields swippy derives, such as ack and distance_unit, are left
+// NULL when absent, since NULL there means the field does not apply.
+func emptyOptionalText(it *eBayItem) {
+	for _, p := range []**string{
+		&it.galleryURL, &it.location, &it.postalCode, &it.productIDType,
+		&it.productIDValue, &it.sellingStatusConvertedCurrentPriceCurrency,
+		&it.sellingStatusCurrentPriceCurrency, &it.sellingStatusSellingState,
+		&it.sellingStatusTimeLeft, &it.shippingServiceCostCurrency,
+		&it.shippingType, &it.shipToLocations, &it.subtitle, &it.viewItemURL,
+	} {
+		if *p == nil {
+			*p = new(string)
+		}
+	}
 }
 
 func firstElem(ss []string) *string {
@@ -0,0 +1,1249 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthewdargan/ebay"
+)
+
+var testTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// captureLog returns a buffer holding what is logged during the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	flags := log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+// stubSleep replaces sleep for the test with a function that returns at
+// once, recording the delays asked for.
+func stubSleep(t *testing.T) *[]time.Duration {
+	var delays []time.Duration
+	sleepFunc := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	}
+	t.Cleanup(func() { sleep = sleepFunc })
+	return &delays
+}
+
+// setVar sets the package variable p, such as a flag, to v for the test.
+func setVar[T any](t *testing.T, p *T, v T) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// testDB returns a connection to a new schema of the database given by
+// TEST_DB_URL, holding the item table and the tables and indexes created by
+// scripts in the sql directory. The schema is dropped when the test ends.
+// The test is skipped if TEST_DB_URL is unset.
+func testDB(t *testing.T, scripts ...string) *sql.DB {
+	dbURL := os.Getenv("TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DB_URL is not set")
+	}
+	admin, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("swippy_test_%d", time.Now().UnixNano())
+	if _, err = admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, name := range append([]string{"create-item.sql"}, scripts...) {
+		b, err := os.ReadFile(filepath.Join("sql", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.Exec(string(b)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	return db
+}
+
+// testItem returns a search item with id and every field swippy requires.
+func testItem(id string) ebay.SearchItem {
+	return ebay.SearchItem{
+		Condition:               []ebay.Condition{{ConditionDisplayName: []string{"New"}, ConditionID: []string{"1000"}}},
+		Country:                 []string{"US"},
+		GlobalID:                []string{"EBAY-US"},
+		IsMultiVariationListing: []string{"false"},
+		ItemID:                  []string{id},
+		ListingInfo: []ebay.ListingInfo{{
+			BestOfferEnabled:  []string{"false"},
+			BuyItNowAvailable: []string{"false"},
+			EndTime:           []time.Time{testTime.Add(24 * time.Hour)},
+			ListingType:       []string{"FixedPrice"},
+			StartTime:         []time.Time{testTime},
+		}},
+		PrimaryCategory: []ebay.Category{{CategoryID: []string{"9355"}, CategoryName: []string{"Cell Phones & Smartphones"}}},
+		Title:           []string{"Phone"},
+		TopRatedListing: []string{"false"},
+	}
+}
+
+// testPage returns the response for page of totalPages holding items.
+func testPage(page, totalPages int, items ...ebay.SearchItem) ebay.FindItemsResponse {
+	return ebay.FindItemsResponse{
+		Ack: []string{"Success"},
+		PaginationOutput: []ebay.PaginationOutput{{
+			PageNumber: []string{strconv.Itoa(page)},
+			TotalPages: []string{strconv.Itoa(totalPages)},
+		}},
+		SearchResult: []ebay.SearchResult{{Count: strconv.Itoa(len(items)), Item: items}},
+		Timestamp:    []time.Time{testTime},
+		Version:      []string{"1.13.0"},
+	}
+}
+
+// A fakeFinder answers every search with the response of its find function.
+type fakeFinder struct {
+	find func(ctx context.Context, params map[string]string) (ebay.FindItemsResponse, error)
+}
+
+func (f *fakeFinder) search(ctx context.Context, params map[string]string) ([]ebay.FindItemsResponse, error) {
+	r, err := f.find(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return []ebay.FindItemsResponse{r}, nil
+}
+
+func (f *fakeFinder) FindItemsAdvanced(ctx context.Context, params map[string]string) (*ebay.FindItemsAdvancedResponse, error) {
+	rs, err := f.search(ctx, params)
+	return &ebay.FindItemsAdvancedResponse{ItemsResponse: rs}, err
+}
+
+func (f *fakeFinder) FindItemsByCategory(ctx context.Context, params map[string]string) (*ebay.FindItemsByCategoryResponse, error) {
+	rs, err := f.search(ctx, params)
+	return &ebay.FindItemsByCategoryResponse{ItemsResponse: rs}, err
+}
+
+func (f *fakeFinder) FindItemsByKeywords(ctx context.Context, params map[string]string) (*ebay.FindItemsByKeywordsResponse, error) {
+	rs, err := f.search(ctx, params)
+	return &ebay.FindItemsByKeywordsResponse{ItemsResponse: rs}, err
+}
+
+func (f *fakeFinder) FindItemsByProduct(ctx context.Context, params map[string]string) (*ebay.FindItemsByProductResponse, error) {
+	rs, err := f.search(ctx, params)
+	return &ebay.FindItemsByProductResponse{ItemsResponse: rs}, err
+}
+
+func (f *fakeFinder) FindItemsInEBayStores(ctx context.Context, params map[string]string) (*ebay.FindItemsInEBayStoresResponse, error) {
+	rs, err := f.search(ctx, params)
+	return &ebay.FindItemsInEBayStoresResponse{ItemsResponse: rs}, err
+}
+
+// pagedFinder returns a finder serving totalPages pages of one item each,
+// and a pointer to the page numbers it was asked for.
+func pagedFinder(totalPages int) (*fakeFinder, *[]int) {
+	var pages []int
+	return &fakeFinder{find: func(ctx context.Context, params map[string]string) (ebay.FindItemsResponse, error) {
+		page, _ := strconv.Atoi(params["paginationInput.pageNumber"])
+		pages = append(pages, page)
+		return testPage(page, totalPages, testItem(strconv.Itoa(page))), nil
+	}}, &pages
+}
+
+func TestItemDistance(t *testing.T) {
+	t.Parallel()
+	it := testItem("1")
+	it.Distance = []ebay.Distance{{Unit: "mi", Value: "12.5"}}
+	got, err := item(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.distanceUnit == nil || *got.distanceUnit != "mi" {
+		t.Errorf("distanceUnit = %v, want mi", got.distanceUnit)
+	}
+	if got.distanceValue == nil || *got.distanceValue != 12.5 {
+		t.Errorf("distanceValue = %v, want 12.5", got.distanceValue)
+	}
+	got, err = item(testItem("2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.distanceUnit != nil || got.distanceValue != nil {
+		t.Errorf("distance = %v %v, want nil without a distance sort", got.distanceValue, got.distanceUnit)
+	}
+}
+
+//nolint:paralleltest // Captures the log.
+func TestLogWarnings(t *testing.T) {
+	buf := captureLog(t)
+	warning := func(msg string) ebay.ErrorMessage {
+		return ebay.ErrorMessage{Error: []ebay.ErrorData{{Severity: []string{"Warning"}, Message: []string{msg}}}}
+	}
+	const deprecated = "findItemsAdvanced is deprecated and will be retired"
+	rs := []ebay.FindItemsResponse{
+		{ErrorMessage: []ebay.ErrorMessage{warning(deprecated), warning("Invalid item filter ignored.")}},
+		{ErrorMessage: []ebay.ErrorMessage{warning(deprecated)}},
+	}
+	logWarnings(rs, []string{deprecated, "itemFilter.name=LotsOnly will be removed"})
+	want := "deprecation warning: " + deprecated + "\n" +
+		"warning: Invalid item filter ignored.\n" +
+		"deprecation warning: itemFilter.name=LotsOnly will be removed\n"
+	if got := buf.String(); got != want {
+		t.Errorf("logged\n%s\nwant\n%s", got, want)
+	}
+	if n := strings.Count(buf.String(), deprecated); n != 1 {
+		t.Errorf("deprecation logged %d times, want 1", n)
+	}
+}
+
+//nolint:paralleltest // Sets -page-delay and replaces sleep.
+func TestFindAllPageDelay(t *testing.T) {
+	old := *pageDelay
+	*pageDelay = 250 * time.Millisecond
+	t.Cleanup(func() { *pageDelay = old })
+	var events []string
+	sleepFunc := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		events = append(events, "sleep "+d.String())
+		return nil
+	}
+	t.Cleanup(func() { sleep = sleepFunc })
+	f := &fakeFinder{find: func(ctx context.Context, params map[string]string) (ebay.FindItemsResponse, error) {
+		page, _ := strconv.Atoi(params["paginationInput.pageNumber"])
+		events = append(events, "page "+params["paginationInput.pageNumber"])
+		return testPage(page, 3, testItem(strconv.Itoa(page))), nil
+	}}
+	if _, _, err := findAll(context.Background(), f, operations["keyword"], map[string]string{"keywords": "delay"}, 100); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"page 1", "sleep 250ms", "page 2", "sleep 250ms", "page 3"}
+	if !slices.Equal(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+}
+
+//nolint:paralleltest // Sets -max-error-rate and captures the log.
+func TestResponseToItemsErrorRate(t *testing.T) {
+	captureLog(t)
+	old := *maxErrorRate
+	t.Cleanup(func() { *maxErrorRate = old })
+	items := []ebay.SearchItem{testItem("1")}
+	for range 4 {
+		items = append(items, testItem("not a number"))
+	}
+	r := testPage(1, 1, items...)
+	keep := func(eBayItem) error { return nil }
+	failed, err := responseToItems(r, keep)
+	if err != nil || failed != 4 {
+		t.Errorf("responseToItems with the default rate = %d, %v, want 4, nil", failed, err)
+	}
+	*maxErrorRate = 0.2
+	if _, err = responseToItems(r, keep); !errors.Is(err, errErrorRateExceeded) {
+		t.Errorf("responseToItems = %v, want %v", err, errErrorRateExceeded)
+	}
+}
+
+//nolint:paralleltest // Captures the log.
+func TestResponseToItemsInvalidID(t *testing.T) {
+	buf := captureLog(t)
+	const id = "99999999999999999999"
+	r := testPage(1, 1, testItem("1"), testItem(id), testItem("3"))
+	var got []int64
+	failed, err := responseToItems(r, func(it eBayItem) error {
+		got = append(got, it.itemID)
+		return nil
+	})
+	if err != nil || failed != 1 {
+		t.Errorf("responseToItems = %d, %v, want 1, nil", failed, err)
+	}
+	if want := []int64{1, 3}; !slices.Equal(got, want) {
+		t.Errorf("items = %v, want %v", got, want)
+	}
+	if want := fmt.Sprintf("invalid itemID %q: %v", id, strconv.ErrRange); !strings.Contains(buf.String(), want) {
+		t.Errorf("logged %q, want it to contain %q", buf.String(), want)
+	}
+}
+
+//nolint:paralleltest // Sets -require-image and captures the log.
+func TestResponseToItemsRequireImage(t *testing.T) {
+	buf := captureLog(t)
+	setVar(t, requireImage, true)
+	gallery, large, none := testItem("1"), testItem("2"), testItem("3")
+	gallery.GalleryURL = []string{"https://i.ebayimg.com/1.jpg"}
+	large.PictureURLLarge = []string{"https://i.ebayimg.com/2.jpg"}
+	var got []int64
+	if _, err := responseToItems(testPage(1, 1, gallery, none, large), func(it eBayItem) error {
+		got = append(got, it.itemID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{1, 2}; !slices.Equal(got, want) {
+		t.Errorf("items = %v, want %v", got, want)
+	}
+	if want := "dropped 1 items without images"; !strings.Contains(buf.String(), want) {
+		t.Errorf("logged %q, want it to contain %q", buf.String(), want)
+	}
+}
+
+//nolint:paralleltest // Captures the log.
+func TestResponseToItemsCountMismatch(t *testing.T) {
+	buf := captureLog(t)
+	r := testPage(1, 1, testItem("1"), testItem("2"))
+	r.SearchResult[0].Count = "5"
+	var n int
+	if _, err := responseToItems(r, func(eBayItem) error {
+		n++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("items = %d, want 2", n)
+	}
+	if want := "eBay reported 5 items but returned 2"; !strings.Contains(buf.String(), want) {
+		t.Errorf("logged %q, want it to contain %q", buf.String(), want)
+	}
+}
+
+func TestResponseToItemsAck(t *testing.T) {
+	t.Parallel()
+	r := testPage(1, 1, testItem("1"))
+	r.Ack = []string{"Warning"}
+	r.ErrorMessage = []ebay.ErrorMessage{{Error: []ebay.ErrorData{{Message: []string{"keywords ignored"}, Severity: []string{"Warning"}}}}}
+	var got []eBayItem
+	if _, err := responseToItems(r, func(it eBayItem) error {
+		got = append(got, it)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("items = %d, want 1", len(got))
+	}
+	if got[0].ack == nil || *got[0].ack != "Warning" {
+		t.Errorf("ack = %v, want Warning", got[0].ack)
+	}
+	if got[0].severity == nil || *got[0].severity != "Warning" {
+		t.Errorf("severity = %v, want Warning", got[0].severity)
+	}
+}
+
+//nolint:paralleltest // Sets -ending-within and pins now.
+func TestEachItemEndingWithin(t *testing.T) {
+	old := *endingWithin
+	*endingWithin = 2 * time.Hour
+	t.Cleanup(func() { *endingWithin = old })
+	pinNow(t, testTime)
+	soon, later := testItem("1"), testItem("2")
+	soon.ListingInfo[0].EndTime = []time.Time{testTime.Add(time.Hour)}
+	later.ListingInfo[0].EndTime = []time.Time{testTime.Add(48 * time.Hour)}
+	var got []int64
+	_, err := eachItem([]ebay.FindItemsResponse{testPage(1, 1, soon, later)}, "{}", func(it eBayItem) error {
+		got = append(got, it.itemID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{1}; !slices.Equal(got, want) {
+		t.Errorf("items = %v, want %v", got, want)
+	}
+}
+
+//nolint:paralleltest // Sets -timestamp-source.
+func TestEachItemTimestampSource(t *testing.T) {
+	setVar(t, tsSource, "ebay")
+	rs := []ebay.FindItemsResponse{testPage(1, 1, testItem("1"))}
+	for _, source := range []string{"ebay", "ingest"} {
+		*tsSource = source
+		var got eBayItem
+		if _, err := eachItem(rs, "{}", func(it eBayItem) error {
+			got = it
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if got.ingestedAt.Equal(testTime) {
+			t.Errorf("%s: ingested_at = %v, want the ingestion time", source, got.ingestedAt)
+		}
+		want := testTime
+		if source == "ingest" {
+			want = got.ingestedAt
+		}
+		if !got.timestamp.Equal(want) {
+			t.Errorf("%s: timestamp = %v, want %v", source, got.timestamp, want)
+		}
+	}
+}
+
+//nolint:paralleltest // Sets -optional-text.
+func TestOptionalTextPolicy(t *testing.T) {
+	old := *textPolicy
+	t.Cleanup(func() { *textPolicy = old })
+	for _, policy := range []string{"null", "empty"} {
+		*textPolicy = policy
+		var got eBayItem
+		_, err := eachItem([]ebay.FindItemsResponse{testPage(1, 1, testItem("1"))}, "{}", func(it eBayItem) error {
+			got = it
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, p := range map[string]*string{"location": got.location, "subtitle": got.subtitle} {
+			if policy == "null" && p != nil {
+				t.Errorf("%s policy: %s = %q, want nil", policy, name, *p)
+			}
+			if policy == "empty" && (p == nil || *p != "") {
+				t.Errorf("%s policy: %s = %v, want empty", policy, name, p)
+			}
+		}
+		for name, p := range map[string]*string{"distanceUnit": got.distanceUnit, "severity": got.severity, "titleNormalized": got.titleNormalized} {
+			if p != nil {
+				t.Errorf("%s policy: %s = %q, want nil", policy, name, *p)
+			}
+		}
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		ps   string
+		want map[string]string
+	}{
+		{"keywords=phone", map[string]string{"keywords": "phone"}},
+		{"itemFilter.value=a=b&keywords=phone", map[string]string{"itemFilter.value": "a=b", "keywords": "phone"}},
+		{"token=YWJj==", map[string]string{"token": "YWJj=="}},
+		{"keywords=iphone%2015", map[string]string{"keywords": "iphone 15"}},
+		{"keywords=c++", map[string]string{"keywords": "c++"}},
+		{"keywords=a%2Bb", map[string]string{"keywords": "a+b"}},
+		{"itemFilter.value=2024-01-02T03%3A04%3A05Z", map[string]string{"itemFilter.value": "2024-01-02T03:04:05Z"}},
+		{"productId.%40type=ISBN", map[string]string{"productId.@type": "ISBN"}},
+	}
+	for _, tt := range tests {
+		got, err := parseParams(tt.ps)
+		if err != nil {
+			t.Errorf("parseParams(%q) error: %v", tt.ps, err)
+			continue
+		}
+		if !maps.Equal(got, tt.want) {
+			t.Errorf("parseParams(%q) = %v, want %v", tt.ps, got, tt.want)
+		}
+	}
+	for _, ps := range []string{"keywords", "keywords=phone&sort", "keywords=100%"} {
+		if _, err := parseParams(ps); err == nil {
+			t.Errorf("parseParams(%q) succeeded, want error", ps)
+		}
+	}
+}
+
+//nolint:paralleltest // Replaces sleep.
+func TestFindAllLastPage(t *testing.T) {
+	stubSleep(t)
+	f, pages := pagedFinder(150)
+	rs, next, err := findAll(context.Background(), f, operations["keyword"], map[string]string{"keywords": "last page", "paginationInput.pageNumber": "99"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{99, 100}; !slices.Equal(*pages, want) {
+		t.Errorf("fetched pages %v, want %v", *pages, want)
+	}
+	if len(rs) != 2 || next != 0 {
+		t.Errorf("findAll = %d responses, next page %d, want 2, 0", len(rs), next)
+	}
+}
+
+//nolint:paralleltest // Replaces sleep and captures the log.
+func TestFindAllPageError(t *testing.T) {
+	captureLog(t)
+	stubSleep(t)
+	errFailed := errors.New("failed")
+	invalidPage := ebay.ErrorMessage{Error: []ebay.ErrorData{{
+		Category: []string{"Request"},
+		Severity: []string{"Error"},
+		Message:  []string{"Invalid page."},
+	}}}
+	tests := []struct {
+		name      string
+		failPage  int
+		fail      func(r *ebay.FindItemsResponse) error
+		wantPages int
+		wantNext  int
+		wantErr   error
+	}{
+		{"later request error", 3, func(*ebay.FindItemsResponse) error { return errFailed }, 2, 3, nil},
+		{"later eBay error", 3, func(r *ebay.FindItemsResponse) error {
+			r.ErrorMessage = []ebay.ErrorMessage{invalidPage}
+			return nil
+		}, 2, 3, nil},
+		{"first request error", 1, func(*ebay.FindItemsResponse) error { return errFailed }, 0, 0, errFailed},
+		{"first eBay error", 1, func(r *ebay.FindItemsResponse) error {
+			r.ErrorMessage = []ebay.ErrorMessage{invalidPage}
+			return nil
+		}, 1, 1, nil},
+	}
+	for _, tt := range tests {
+		f := &fakeFinder{find: func(ctx context.Context, params map[string]string) (ebay.FindItemsResponse, error) {
+			page, _ := strconv.Atoi(params["paginationInput.pageNumber"])
+			r := testPage(page, 5, testItem(strconv.Itoa(page)))
+			if page == tt.failPage {
+				if err := tt.fail(&r); err != nil {
+					return ebay.FindItemsResponse{}, err
+				}
+			}
+			return r, nil
+		}}
+		rs, next, err := findAll(context.Background(), f, operations["keyword"], map[string]string{"keywords": tt.name}, 100)
+		if !errors.Is(err, tt.wantErr) || len(rs) != tt.wantPages || next != tt.wantNext {
+			t.Errorf("%s: findAll = %d responses, next page %d, %v, want %d, %d, %v", tt.name, len(rs), next, err, tt.wantPages, tt.wantNext, tt.wantErr)
+		}
+	}
+}
+
+//nolint:paralleltest // Sets -page-delay and captures the log.
+func TestFindAllDeadline(t *testing.T) {
+	captureLog(t)
+	old := *pageDelay
+	*pageDelay = 0
+	t.Cleanup(func() { *pageDelay = old })
+	f := &fakeFinder{find: func(ctx context.Context, params map[string]string) (ebay.FindItemsResponse, error) {
+		if err := sleep(ctx, 20*time.Millisecond); err != nil {
+			return ebay.FindItemsResponse{}, err
+		}
+		page, _ := strconv.Atoi(params["paginationInput.pageNumber"])
+		return testPage(page, 100, testItem(strconv.Itoa(page))), nil
+	}}
+	const deadline = 100 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	start := time.Now()
+	rs, next, err := findAll(ctx, f, operations["keyword"], map[string]string{"keywords": "deadline"}, 100)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) == 0 || len(rs) >= 100 {
+		t.Errorf("fetched %d pages, want some but not all", len(rs))
+	}
+	if next != len(rs)+1 {
+		t.Errorf("next page = %d, want %d", next, len(rs)+1)
+	}
+	if elapsed > deadline+time.Second {
+		t.Errorf("findAll took %v with a %v deadline", elapsed, deadline)
+	}
+}
+
+func TestUpsertQuery(t *testing.T) {
+	t.Parallel()
+	got := upsertQuery([]string{"timestamp", "item_id", "title"})
+	want := `INSERT INTO item ("timestamp", "item_id", "title")` +
+		` SELECT DISTINCT ON ("item_id") "timestamp", "item_id", "title" FROM item_upsert` +
+		` ORDER BY "item_id", "timestamp" DESC` +
+		` ON CONFLICT ("item_id") DO UPDATE SET "timestamp" = EXCLUDED."timestamp", "title" = EXCLUDED."title"` +
+		` RETURNING xmax = 0`
+	if got != want {
+		t.Errorf("upsertQuery =\n%s\nwant\n%s", got, want)
+	}
+}
+
+//nolint:paralleltest // Sets column names.
+func TestUpsertQueryColumnNames(t *testing.T) {
+	for _, m := range []string{"item_id=Item ID", "timestamp=order", "title=x\"y"} {
+		if err := setColumnName(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { clear(columnNames) })
+	got := upsertQuery([]string{columnName("timestamp"), columnName("item_id"), columnName("title")})
+	want := `INSERT INTO item ("order", "Item ID", "x""y")` +
+		` SELECT DISTINCT ON ("Item ID") "order", "Item ID", "x""y" FROM item_upsert` +
+		` ORDER BY "Item ID", "order" DESC` +
+		` ON CONFLICT ("Item ID") DO UPDATE SET "order" = EXCLUDED."order", "x""y" = EXCLUDED."x""y"` +
+		` RETURNING xmax = 0`
+	if got != want {
+		t.Errorf("upsertQuery =\n%s\nwant\n%s", got, want)
+	}
+}
+
+//nolint:paralleltest // Sets -verbose and -quiet.
+func TestLogLevels(t *testing.T) {
+	oldVerbose, oldQuiet := *verbose, *quiet
+	t.Cleanup(func() { *verbose, *quiet = oldVerbose, oldQuiet })
+	tests := []struct {
+		verbose, quiet bool
+		want           string
+	}{
+		{false, false, "warning\n"},
+		{true, false, "info\nwarning\n"},
+		{false, true, ""},
+	}
+	for _, tt := range tests {
+		buf := captureLog(t)
+		*verbose, *quiet = tt.verbose, tt.quiet
+		infof("info")
+		warnf("warning")
+		if got := buf.String(); got != tt.want {
+			t.Errorf("verbose %t, quiet %t: logged %q, want %q", tt.verbose, tt.quiet, got, tt.want)
+		}
+	}
+}
+
+//nolint:paralleltest // Sets -quiet.
+func TestLogVersionQuiet(t *testing.T) {
+	old := *quiet
+	*quiet = true
+	t.Cleanup(func() { *quiet = old })
+	buf := captureLog(t)
+	r := testPage(1, 1)
+	logVersion([]ebay.FindItemsResponse{r}, "1.12.0")
+	logWarnings([]ebay.FindItemsResponse{r}, []string{"deprecated"})
+	if buf.Len() > 0 {
+		t.Errorf("logged %q with -quiet", buf.String())
+	}
+	var summary bytes.Buffer
+	printSummary(&summary, runSummary{operation: "keyword", fetched: 1})
+	if !strings.Contains(summary.String(), "fetched") {
+		t.Errorf("summary %q lacks the fetched count", summary.String())
+	}
+}
+
+func TestAPIErrorTemporary(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		category, domain, subdomain string
+		want                        bool
+	}{
+		{"System", "Marketplace", "Search", true},
+		{"Request", "Marketplace", "Search", false},
+		{"Application", "Marketplace", "Search", false},
+		{"Request", "ServiceError", "", true},
+		{"Application", "Marketplace", "ServiceError", true},
+		{"System", "RequestError", "", false},
+		{"System", "Security", "", false},
+	}
+	for _, tt := range tests {
+		r := ebay.FindItemsResponse{ErrorMessage: []ebay.ErrorMessage{{Error: []ebay.ErrorData{{
+			Category:  []string{tt.category},
+			Domain:    []string{tt.domain},
+			Subdomain: []string{tt.subdomain},
+			Severity:  []string{"Error"},
+		}}}}}
+		var e *apiError
+		if !errors.As(responseError(r), &e) {
+			t.Fatalf("responseError(%v) is not an *apiError", r)
+		}
+		if got := e.temporary(); got != tt.want {
+			t.Errorf("temporary for category %s, domain %s, subdomain %s = %t, want %t", tt.category, tt.domain, tt.subdomain, got, tt.want)
+		}
+	}
+}
+
+//nolint:paralleltest // Replaces sleep.
+func TestFindPageRetriesServiceError(t *testing.T) {
+	stubSleep(t)
+	var calls int
+	f := &fakeFinder{find: func(ctx context.Context, params map[string]string) (ebay.FindItemsResponse, error) {
+		calls++
+		r := testPage(1, 1, testItem("1"))
+		if calls == 1 {
+			r.ErrorMessage = []ebay.ErrorMessage{{Error: []ebay.ErrorData{{
+				Category: []string{"Request"},
+				Domain:   []string{"ServiceError"},
+				Severity: []string{"Error"},
+			}}}}
+		}
+		return r, nil
+	}}
+	rs, err := findPage(context.Background(), f, operations["keyword"], map[string]string{"keywords": "service error"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || responseError(rs[0]) != nil {
+		t.Errorf("findPage made %d calls ending with %v, want 2 ending in success", calls, responseError(rs[0]))
+	}
+}
+
+//nolint:paralleltest // Sets -upsert.
+func TestInsertItemsUpsert(t *testing.T) {
+	db := testDB(t, "create-item-id-index.sql")
+	setVar(t, upsert, true)
+	discard := func(eBayItem) error { return nil }
+	rs := []ebay.FindItemsResponse{testPage(1, 1, testItem("1"), testItem("1"))}
+	inserted, updated, _, err := insertItems(db, rs, "{}", discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 1 || updated != 0 {
+		t.Errorf("first run inserted %d and updated %d, want 1 and 0", inserted, updated)
+	}
+	inserted, updated, _, err = insertItems(db, rs, "{}", discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 0 || updated != 1 {
+		t.Errorf("second run inserted %d and updated %d, want 0 and 1", inserted, updated)
+	}
+	var n int
+	if err = db.QueryRow("SELECT count(*) FROM item WHERE item_id = 1").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("stored %d rows for the item, want 1", n)
+	}
+	*upsert = false
+	if _, _, _, err = insertItems(db, rs, "{}", discard); err == nil {
+		t.Error("insertItems without -upsert stored an item already stored")
+	}
+}
+
+func TestChangeLogQuery(t *testing.T) {
+	t.Parallel()
+	got := changeLogQuery()
+	want := `INSERT INTO item_changes (item_id, changed_at, old_price, new_price, old_watch_count, new_watch_count, old_selling_state, new_selling_state)` +
+		` SELECT i."item_id", u."timestamp",` +
+		` i."selling_status_current_price_value", u."selling_status_current_price_value",` +
+		` i."listing_info_watch_count", u."listing_info_watch_count",` +
+		` i."selling_status_selling_state", u."selling_status_selling_state"` +
+		` FROM item i JOIN (SELECT DISTINCT ON ("item_id") * FROM item_upsert ORDER BY "item_id", "timestamp" DESC) u` +
+		` ON u."item_id" = i."item_id"` +
+		` WHERE (i."selling_status_current_price_value", i."listing_info_watch_count", i."selling_status_selling_state")` +
+		` IS DISTINCT FROM (u."selling_status_current_price_value", u."listing_info_watch_count", u."selling_status_selling_state")`
+	if got != want {
+		t.Errorf("changeLogQuery =\n%s\nwant\n%s", got, want)
+	}
+}
+
+//nolint:paralleltest // Sets -upsert and -change-log.
+func TestInsertItemsChangeLog(t *testing.T) {
+	db := testDB(t, "create-item-id-index.sql", "create-item-changes.sql")
+	setVar(t, upsert, true)
+	setVar(t, changeLog, true)
+	priced := func(price string) []ebay.FindItemsResponse {
+		it := testItem("1")
+		it.SellingStatus = []ebay.SellingStatus{{
+			CurrentPrice: []ebay.Price{{CurrencyID: "USD", Value: price}},
+			SellingState: []string{"Active"},
+		}}
+		return []ebay.FindItemsResponse{testPage(1, 1, it)}
+	}
+	discard := func(eBayItem) error { return nil }
+	for _, price := range []string{"10.00", "10.00", "8.00"} {
+		if _, _, _, err := insertItems(db, priced(price), "{}", discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, err := db.Query("SELECT item_id, old_price, new_price FROM item_changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var changes []string
+	for rows.Next() {
+		var id int64
+		var oldPrice, newPrice float64
+		if err = rows.Scan(&id, &oldPrice, &newPrice); err != nil {
+			t.Fatal(err)
+		}
+		changes = append(changes, fmt.Sprintf("%d %.2f -> %.2f", id, oldPrice, newPrice))
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1 10.00 -> 8.00"}; !slices.Equal(changes, want) {
+		t.Errorf("changes = %q, want %q", changes, want)
+	}
+}
+
+//nolint:paralleltest // Registers operations.
+func TestRegisterAlias(t *testing.T) {
+	if err := registerAlias("books=product:productId.@type=ISBN"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { delete(operations, "books") })
+	if got := operations["books"].name; got != "product" {
+		t.Errorf("books dispatches to %s, want product", got)
+	}
+	tests := []struct {
+		params, wantType string
+	}{
+		{"productId=9780131103627", "ISBN"},
+		{"productId=012345678905&productId.@type=UPC", "UPC"},
+	}
+	for _, tt := range tests {
+		params, err := loadParams("books", tt.params)
+		if err != nil {
+			t.Errorf("loadParams(books, %q): %v", tt.params, err)
+			continue
+		}
+		if got := params["productId.@type"]; got != tt.wantType {
+			t.Errorf("loadParams(books, %q) productId.@type = %q, want %q", tt.params, got, tt.wantType)
+		}
+	}
+	if _, err := loadParams("books", "keywords=go"); !errors.Is(err, errMissingProductID) {
+		t.Errorf("loadParams(books) without productId = %v, want %v", err, errMissingProductID)
+	}
+	for _, s := range []string{"keyword=product:productId.@type=ISBN", "validate=keyword", "=keyword", "phones=unknown"} {
+		if err := registerAlias(s); err == nil {
+			t.Errorf("registerAlias(%q) succeeded, want error", s)
+		}
+	}
+	if got := operations["keyword"].name; got != "keyword" {
+		t.Errorf("keyword dispatches to %s after a rejected alias, want keyword", got)
+	}
+}
+
+func TestRequestParams(t *testing.T) {
+	t.Parallel()
+	params := map[string]string{
+		"Security-AppName":    "secret-app-id",
+		"keywords":            "phone",
+		"itemFilter(0).name":  "MaxPrice",
+		"itemFilter(0).value": "100",
+	}
+	s, err := requestParams(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(s, "secret-app-id") {
+		t.Errorf("requestParams = %s, which contains the app ID", s)
+	}
+	var got map[string]string
+	if err = json.Unmarshal([]byte(s), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"keywords": "phone", "itemFilter(0).name": "MaxPrice", "itemFilter(0).value": "100"}
+	if !maps.Equal(got, want) {
+		t.Errorf("requestParams = %v, want %v", got, want)
+	}
+}
+
+//nolint:paralleltest // Sets -total-cost.
+func TestShippingRows(t *testing.T) {
+	setVar(t, storeTotal, true)
+	si := testItem("1")
+	si.SellingStatus = []ebay.SellingStatus{{CurrentPrice: []ebay.Price{{CurrencyID: "USD", Value: "20.00"}}}}
+	si.ShippingInfo = []ebay.ShippingInfo{{ShippingServiceCost: []ebay.Price{
+		{CurrencyID: "USD", Value: "0.00"},
+		{CurrencyID: "USD", Value: "7.50"},
+	}}}
+	it, err := item(si)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := shippingRows(it, si.ShippingInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("shippingRows = %d rows, want 2", len(rows))
+	}
+	for i, want := range []struct{ cost, total float64 }{{0, 20}, {7.5, 27.5}} {
+		r := rows[i]
+		if r.itemID != 1 || *r.shippingServiceCostValue != want.cost || *r.shippingServiceCostCurrency != "USD" {
+			t.Errorf("row %d: item %d shipping %v %v, want item 1 shipping %v USD", i, r.itemID, *r.shippingServiceCostValue, *r.shippingServiceCostCurrency, want.cost)
+		}
+		if r.totalCost == nil || *r.totalCost != want.total {
+			t.Errorf("row %d: totalCost = %v, want %v", i, r.totalCost, want.total)
+		}
+	}
+	rows, err = shippingRows(it, si.ShippingInfo[:0])
+	if err != nil || len(rows) != 1 {
+		t.Errorf("shippingRows without shipping = %d rows, %v, want 1, nil", len(rows), err)
+	}
+}
+
+//nolint:paralleltest // Captures the log.
+func TestLogVersion(t *testing.T) {
+	page := func(version string) ebay.FindItemsResponse {
+		r := testPage(1, 1)
+		r.Version = []string{version}
+		return r
+	}
+	tests := []struct {
+		versions []string
+		want     string
+		logged   string
+	}{
+		{[]string{"1.13.0", "1.13.0"}, "", ""},
+		{[]string{"1.13.0", "1.13.0"}, "1.13.0", ""},
+		{[]string{"1.13.0"}, "1.12.0", "warning: eBay API version 1.13.0 differs from expected version 1.12.0\n"},
+		{[]string{"1.13.0", "1.14.0"}, "", "warning: eBay API version changed from 1.13.0 to 1.14.0\n"},
+	}
+	for _, tt := range tests {
+		buf := captureLog(t)
+		var rs []ebay.FindItemsResponse
+		for _, v := range tt.versions {
+			rs = append(rs, page(v))
+		}
+		logVersion(rs, tt.want)
+		if got := buf.String(); got != tt.logged {
+			t.Errorf("logVersion(%v, %q) logged %q, want %q", tt.versions, tt.want, got, tt.logged)
+		}
+	}
+}
+
+//nolint:paralleltest // Sets -dry-run, replaces openDB, and captures the log.
+func TestSaveItemsDryRun(t *testing.T) {
+	setVar(t, dryRun, true)
+	setVar(t, &openDB, func() (*sql.DB, error) {
+		t.Error("dry run opened the database")
+		return nil, errors.New("no database")
+	})
+	buf := captureLog(t)
+	var written int
+	rs := []ebay.FindItemsResponse{testPage(1, 1, testItem("1"), testItem("2"))}
+	inserted, _, skipped, err := saveItems(rs, "{}", func(eBayItem) error {
+		written++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 0 || skipped != 0 || written != 2 {
+		t.Errorf("saveItems = %d inserted, %d skipped, %d written, want 0, 0, 2", inserted, skipped, written)
+	}
+	if want := "dry run: 2 items would be inserted\n"; buf.String() != want {
+		t.Errorf("logged %q, want %q", buf.String(), want)
+	}
+}
+
+// BenchmarkConvertItems compares converting a large response item by item,
+// as insertItems does while copying, with collecting every converted item
+// before copying, which holds the whole response in memory at once.
+func BenchmarkConvertItems(b *testing.B) {
+	items := make([]ebay.SearchItem, 10000)
+	for i := range items {
+		items[i] = testItem(strconv.Itoa(i + 1))
+	}
+	rs := []ebay.FindItemsResponse{testPage(1, 1, items...)}
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			var last eBayItem
+			if _, err := eachItem(rs, "{}", func(it eBayItem) error {
+				last = it
+				return nil
+			}); err != nil {
+				b.Fatal(err)
+			}
+			_ = last
+		}
+	})
+	b.Run("collect", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			var all []eBayItem
+			if _, err := eachItem(rs, "{}", func(it eBayItem) error {
+				all = append(all, it)
+				return nil
+			}); err != nil {
+				b.Fatal(err)
+			}
+			_ = all
+		}
+	})
+}
+
+//nolint:paralleltest // Sets environment variables.
+func TestAppID(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "app-id")
+	if err := os.WriteFile(name, []byte("file-app-id\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EBAY_APP_ID_FILE", name)
+	tests := []struct {
+		env, want string
+	}{
+		{"", "file-app-id"},
+		{"env-app-id", "env-app-id"},
+	}
+	for _, tt := range tests {
+		t.Setenv("EBAY_APP_ID", tt.env)
+		if got, err := appID(); err != nil || got != tt.want {
+			t.Errorf("appID with EBAY_APP_ID %q = %q, %v, want %q, nil", tt.env, got, err, tt.want)
+		}
+	}
+	t.Setenv("EBAY_APP_ID", "")
+	t.Setenv("EBAY_APP_ID_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := appID(); err == nil {
+		t.Error("appID with a missing EBAY_APP_ID_FILE succeeded, want error")
+	}
+}
+
+// testConverted returns the item converted from testItem(id).
+func testConverted(id int64) eBayItem {
+	return eBayItem{
+		conditionDisplayName:   "New",
+		conditionID:            1000,
+		country:                "US",
+		globalID:               "EBAY-US",
+		itemID:                 id,
+		listingInfoEndTime:     testTime.Add(24 * time.Hour),
+		listingInfoListingType: "FixedPrice",
+		listingInfoStartTime:   testTime,
+		primaryCategoryID:      9355,
+		primaryCategoryName:    "Cell Phones & Smartphones",
+		title:                  "Phone",
+	}
+}
+
+// columnValues returns the values it stores in each item table column, with
+// pointers replaced by the values they point to, for comparing items.
+func columnValues(it eBayItem) map[string]any {
+	values := make(map[string]any, len(itemColumns))
+	for _, c := range itemColumns {
+		v := reflect.ValueOf(c.value(&it))
+		switch {
+		case v.Kind() != reflect.Pointer:
+			values[c.name] = v.Interface()
+		case v.IsNil():
+			values[c.name] = nil
+		default:
+			values[c.name] = v.Elem().Interface()
+		}
+	}
+	return values
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+//nolint:paralleltest // Sets flags for some cases and captures the log.
+func TestItem(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func()
+		edit   func(it *ebay.SearchItem)
+		want   func(it *eBayItem)
+		err    error
+		logged string
+	}{
+		{
+			name: "handling time and shipping flags",
+			edit: func(it *ebay.SearchItem) {
+				it.ShippingInfo = []ebay.ShippingInfo{{
+					HandlingTime:            []string{"3"},
+					ExpeditedShipping:       []string{"true"},
+					OneDayShippingAvailable: []string{"false"},
+				}}
+			},
+			want: func(it *eBayItem) {
+				it.handlingTime = ptr(3)
+				it.expeditedShipping = ptr(true)
+				it.oneDayShippingAvailable = ptr(false)
+			},
+		},
+		{
+			name: "bid count",
+			edit: func(it *ebay.SearchItem) {
+				it.SellingStatus = []ebay.SellingStatus{{BidCount: []string{"7"}}}
+			},
+			want: func(it *eBayItem) {
+				it.sellingStatusBidCount = ptr(7)
+			},
+		},
+		{
+			name: "category ID beyond int32",
+			edit: func(it *ebay.SearchItem) {
+				it.PrimaryCategory[0].CategoryID = []string{"12345678901"}
+			},
+			want: func(it *eBayItem) {
+				it.primaryCategoryID = 12345678901
+			},
+		},
+		{
+			name: "current price without converted price",
+			edit: func(it *ebay.SearchItem) {
+				it.SellingStatus = []ebay.SellingStatus{{CurrentPrice: []ebay.Price{{CurrencyID: "USD", Value: "19.99"}}}}
+			},
+			want: func(it *eBayItem) {
+				it.sellingStatusCurrentPriceCurrency = ptr("USD")
+				it.sellingStatusCurrentPriceValue = ptr(19.99)
+			},
+		},
+		{
+			name: "ReferenceID product",
+			edit: func(it *ebay.SearchItem) {
+				it.ProductID = []ebay.ProductID{{Type: "ReferenceID", Value: "4034210179"}}
+			},
+			want: func(it *eBayItem) {
+				it.productIDType = ptr("ReferenceID")
+				it.productIDValue = ptr("4034210179")
+			},
+		},
+		{
+			name:  "total cost with shipping",
+			setup: func() { *storeTotal = true },
+			edit: func(it *ebay.SearchItem) {
+				it.SellingStatus = []ebay.SellingStatus{{CurrentPrice: []ebay.Price{{CurrencyID: "USD", Value: "20.00"}}}}
+				it.ShippingInfo = []ebay.ShippingInfo{{ShippingServiceCost: []ebay.Price{{CurrencyID: "USD", Value: "5.50"}}}}
+			},
+			want: func(it *eBayItem) {
+				it.sellingStatusCurrentPriceCurrency = ptr("USD")
+				it.sellingStatusCurrentPriceValue = ptr(20.0)
+				it.shippingServiceCostCurrency = ptr("USD")
+				it.shippingServiceCostValue = ptr(5.5)
+				it.totalCost = ptr(25.5)
+			},
+		},
+		{
+			name:  "total cost without shipping",
+			setup: func() { *storeTotal = true },
+			edit: func(it *ebay.SearchItem) {
+				it.SellingStatus = []ebay.SellingStatus{{CurrentPrice: []ebay.Price{{CurrencyID: "USD", Value: "20.00"}}}}
+			},
+			want: func(it *eBayItem) {
+				it.sellingStatusCurrentPriceCurrency = ptr("USD")
+				it.sellingStatusCurrentPriceValue = ptr(20.0)
+			},
+		},
+		{
+			name: "known and unknown payment methods",
+			edit: func(it *ebay.SearchItem) {
+				it.PaymentMethod = []string{"paypal", "Bitcoin"}
+			},
+			want: func(it *eBayItem) {
+				it.paymentMethods = []string{"PayPal", "Bitcoin"}
+			},
+			logged: `item 1 has unknown payment method "Bitcoin"`,
+		},
+		{
+			name:  "normalized title",
+			setup: func() { *normTitle = true },
+			edit: func(it *ebay.SearchItem) {
+				it.Title = []string{"  Apple\tiPhone  15 "}
+			},
+			want: func(it *eBayItem) {
+				it.title = "  Apple\tiPhone  15 "
+				it.titleNormalized = ptr("apple iphone 15")
+			},
+		},
+		{
+			name: "long title",
+			edit: func(it *ebay.SearchItem) {
+				it.Title = []string{strings.Repeat("é", 100)}
+			},
+			want: func(it *eBayItem) {
+				it.title = strings.Repeat("é", 100)
+			},
+			logged: "item 1 has an unusually long title (100 characters)",
+		},
+		{
+			name: "affiliate params",
+			setup: func() {
+				if err := setAffiliateParams("campid=5338000000&mkcid=1"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			edit: func(it *ebay.SearchItem) {
+				it.ViewItemURL = []string{"https://www.ebay.com/itm/1?hash=x"}
+			},
+			want: func(it *eBayItem) {
+				it.viewItemURL = ptr("https://www.ebay.com/itm/1?campid=5338000000&hash=x&mkcid=1")
+			},
+		},
+		{
+			name: "missing condition",
+			edit: func(it *ebay.SearchItem) {
+				it.Condition = nil
+			},
+			err: errMissingField,
+		},
+		{
+			name: "condition without ID",
+			edit: func(it *ebay.SearchItem) {
+				it.Condition[0].ConditionID = nil
+			},
+			err: errMissingField,
+		},
+		{
+			name: "missing listing info",
+			edit: func(it *ebay.SearchItem) {
+				it.ListingInfo = nil
+			},
+			err: errMissingField,
+		},
+		{
+			name: "empty shipping and selling status",
+			edit: func(it *ebay.SearchItem) {
+				it.SellingStatus = []ebay.SellingStatus{{}}
+				it.ShippingInfo = []ebay.ShippingInfo{{}}
+			},
+		},
+	}
+	setVar(t, storeTotal, false)
+	setVar(t, normTitle, false)
+	setVar(t, &affiliateParams, nil)
+	for _, tt := range tests {
+		buf := captureLog(t)
+		*storeTotal, *normTitle, affiliateParams = false, false, nil
+		if tt.setup != nil {
+			tt.setup()
+		}
+		si := testItem("1")
+		if tt.edit != nil {
+			tt.edit(&si)
+		}
+		got, err := item(si)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: item = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if !strings.Contains(buf.String(), tt.logged) || (tt.logged == "" && buf.Len() > 0) {
+			t.Errorf("%s: logged %q, want %q", tt.name, buf.String(), tt.logged)
+		}
+		if err != nil {
+			continue
+		}
+		want := testConverted(1)
+		if tt.want != nil {
+			tt.want(&want)
+		}
+		if g, w := columnValues(got), columnValues(want); !reflect.DeepEqual(g, w) {
+			t.Errorf("%s: item =\n%v\nwant\n%v", tt.name, g, w)
+		}
+	}
+}
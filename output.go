@@ -0,0 +1,66 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// newItemWriter returns a function that writes items to w in format, and a
+// function to call once all items have been written. Format is one of json,
+// ndjson, table, or the empty string for no output. JSON arrays are indented
+// by indent spaces per level, or written compactly if indent is 0.
+func newItemWriter(w io.Writer, format string, indent int) (write func(eBayItem) error, flush func() error, err error) {
+	if indent < 0 {
+		return nil, nil, fmt.Errorf("invalid indent %d: must not be negative", indent)
+	}
+	switch format {
+	case "":
+		return func(eBayItem) error { return nil }, func() error { return nil }, nil
+	case "json":
+		views := []map[string]any{}
+		write = func(it eBayItem) error {
+			views = append(views, it.view())
+			return nil
+		}
+		flush = func() error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", strings.Repeat(" ", indent))
+			return enc.Encode(views)
+		}
+		return write, flush, nil
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		write = func(it eBayItem) error {
+			return enc.Encode(it.view())
+		}
+		return write, func() error { return nil }, nil
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ITEM ID\tTITLE\tPRICE")
+		write = func(it eBayItem) error {
+			price := "-"
+			if it.sellingStatusCurrentPriceValue != nil {
+				price = fmt.Sprintf("%.2f %s", *it.sellingStatusCurrentPriceValue, *it.sellingStatusCurrentPriceCurrency)
+			}
+			_, err := fmt.Fprintf(tw, "%d\t%s\t%s\n", it.itemID, it.title, price)
+			return err
+		}
+		return write, tw.Flush, nil
+	}
+	return nil, nil, fmt.Errorf("invalid format %q: must be json, ndjson, or table", format)
+}
+
+// view returns the exported view of it, keyed by item table column names.
+func (it *eBayItem) view() map[string]any {
+	v := make(map[string]any, len(itemColumns))
+	for _, c := range itemColumns {
+		v[c.name] = c.value(it)
+	}
+	return v
+}
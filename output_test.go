@@ -0,0 +1,144 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matthewdargan/ebay"
+)
+
+// testItems returns the converted items for ids, the first priced at 20 USD.
+func testItems(t *testing.T, ids ...string) []eBayItem {
+	t.Helper()
+	items := make([]eBayItem, len(ids))
+	for i, id := range ids {
+		si := testItem(id)
+		if i == 0 {
+			si.SellingStatus = []ebay.SellingStatus{{CurrentPrice: []ebay.Price{{CurrencyID: "USD", Value: "20.00"}}}}
+		}
+		it, err := item(si)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items[i] = it
+	}
+	return items
+}
+
+// writeItems writes items to a buffer with the item writer for format and
+// indent, and returns what was written.
+func writeItems(t *testing.T, format string, indent int, items []eBayItem) string {
+	t.Helper()
+	var buf bytes.Buffer
+	write, flush, err := newItemWriter(&buf, format, indent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range items {
+		if err = write(it); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestItemWriter(t *testing.T) {
+	t.Parallel()
+	items := testItems(t, "1", "2")
+	if got := writeItems(t, "", 2, items); got != "" {
+		t.Errorf("no format wrote %q, want nothing", got)
+	}
+	var arr []map[string]any
+	if err := json.Unmarshal([]byte(writeItems(t, "json", 2, items)), &arr); err != nil {
+		t.Fatal(err)
+	}
+	if len(arr) != 2 || arr[0]["item_id"] != 1.0 || arr[1]["item_id"] != 2.0 {
+		t.Errorf("json wrote %v, want items 1 and 2", arr)
+	}
+	lines := strings.Split(strings.TrimSuffix(writeItems(t, "ndjson", 2, items), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("ndjson wrote %d lines, want 2", len(lines))
+	}
+	for i, line := range lines {
+		var obj map[string]any
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			t.Fatalf("ndjson line %d: %v", i, err)
+		}
+		if want := float64(i + 1); obj["item_id"] != want {
+			t.Errorf("ndjson line %d item_id = %v, want %v", i, obj["item_id"], want)
+		}
+	}
+	want := "ITEM ID  TITLE  PRICE\n" +
+		"1        Phone  20.00 USD\n" +
+		"2        Phone  -\n"
+	if got := writeItems(t, "table", 2, items); got != want {
+		t.Errorf("table wrote\n%s\nwant\n%s", got, want)
+	}
+	if _, _, err := newItemWriter(&bytes.Buffer{}, "csv", 2); err == nil {
+		t.Error("newItemWriter(csv) succeeded, want error")
+	}
+}
+
+func TestItemWriterIndent(t *testing.T) {
+	t.Parallel()
+	items := testItems(t, "1")
+	compact := writeItems(t, "json", 0, items)
+	if !strings.HasPrefix(compact, `[{"`) || strings.Count(compact, "\n") != 1 {
+		t.Errorf("indent 0 wrote %q, want a single compact line", compact)
+	}
+	indented := writeItems(t, "json", 4, items)
+	if !strings.HasPrefix(indented, "[\n    {\n        \"") {
+		t.Errorf("indent 4 wrote %q, want 4 spaces per level", indented)
+	}
+	var a, b []map[string]any
+	if err := json.Unmarshal([]byte(compact), &a); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(indented), &b); err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 1 || len(b) != 1 || a[0]["item_id"] != b[0]["item_id"] {
+		t.Errorf("compact %v and indented %v hold different items", a, b)
+	}
+	if _, _, err := newItemWriter(&bytes.Buffer{}, "json", -1); err == nil {
+		t.Error("newItemWriter with indent -1 succeeded, want error")
+	}
+}
+
+func TestItemViewKeys(t *testing.T) {
+	t.Parallel()
+	var got []map[string]any
+	if err := json.Unmarshal([]byte(writeItems(t, "json", 0, testItems(t, "1"))), &got); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join("sql", "create-item.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tableColumns []string
+	for _, line := range strings.Split(string(b), "\n")[1:] {
+		if f := strings.Fields(line); len(f) > 1 && f[0] != "id" {
+			tableColumns = append(tableColumns, f[0])
+		}
+	}
+	for _, names := range [][]string{copyColumnNames(), tableColumns} {
+		if len(got[0]) != len(names) {
+			t.Errorf("JSON has %d keys, want %d", len(got[0]), len(names))
+		}
+		for _, n := range names {
+			if _, ok := got[0][n]; !ok {
+				t.Errorf("JSON lacks column %s", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,13 @@
+-- Required by -change-log. Each row records how a run with -upsert changed
+-- the price, watch count, or selling state of a stored item.
+CREATE TABLE item_changes (
+    id INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    item_id BIGINT NOT NULL,
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    old_price NUMERIC,
+    new_price NUMERIC,
+    old_watch_count INT,
+    new_watch_count INT,
+    old_selling_state TEXT,
+    new_selling_state TEXT
+);
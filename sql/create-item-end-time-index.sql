@@ -0,0 +1,2 @@
+-- Speeds up finding auctions that end soon. Safe to run on existing tables.
+CREATE INDEX IF NOT EXISTS item_listing_info_end_time_idx ON item (listing_info_end_time);
@@ -0,0 +1,4 @@
+-- Required by -upsert. Existing duplicate item_id rows must be removed first.
+-- Once the index exists, runs without -upsert fail when they store an item
+-- already in the table, and -flatten-shipping cannot store items at all.
+CREATE UNIQUE INDEX item_item_id_idx ON item (item_id);
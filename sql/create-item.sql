@@ -1,12 +1,20 @@
 CREATE TABLE item (
     id INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
     timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
+    ingested_at TIMESTAMP WITH TIME ZONE NOT NULL,
     version TEXT NOT NULL,
+    ack TEXT,
+    severity TEXT,
+    request_params JSONB NOT NULL,
     condition_display_name TEXT NOT NULL,
     condition_id INT NOT NULL,
     country TEXT NOT NULL,
+    distance_unit TEXT,
+    distance_value NUMERIC,
+    expedited_shipping BOOLEAN,
     gallery_url TEXT,
     global_id TEXT NOT NULL,
+    handling_time INT,
     is_multi_variation_listing BOOLEAN NOT NULL,
     item_id BIGINT NOT NULL,
     listing_info_best_offer_enabled BOOLEAN NOT NULL,
@@ -16,11 +24,14 @@ CREATE TABLE item (
     listing_info_start_time TIMESTAMP WITH TIME ZONE NOT NULL,
     listing_info_watch_count INT,
     location TEXT,
+    one_day_shipping_available BOOLEAN,
+    payment_methods TEXT[],
     postal_code TEXT,
     primary_category_id BIGINT NOT NULL,
     primary_category_name TEXT NOT NULL,
     product_id_type TEXT,
-    product_id_value BIGINT,
+    product_id_value TEXT,
+    selling_status_bid_count INT,
     selling_status_converted_current_price_currency TEXT,
     selling_status_converted_current_price_value NUMERIC,
     selling_status_current_price_currency TEXT,
@@ -33,6 +44,8 @@ CREATE TABLE item (
     ship_to_locations TEXT,
     subtitle TEXT,
     title TEXT NOT NULL,
+    title_normalized TEXT,
     top_rated_listing BOOLEAN NOT NULL,
+    total_cost NUMERIC,
     view_item_url TEXT
 );
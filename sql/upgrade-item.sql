@@ -0,0 +1,28 @@
+-- Upgrades an item table created by an earlier sql/create-item.sql to the
+-- current columns. Rows stored before the upgrade get an empty
+-- request_params object and their timestamp as ingested_at.
+BEGIN;
+
+ALTER TABLE item
+    ADD COLUMN IF NOT EXISTS ingested_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS ack TEXT,
+    ADD COLUMN IF NOT EXISTS severity TEXT,
+    ADD COLUMN IF NOT EXISTS request_params JSONB NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS distance_unit TEXT,
+    ADD COLUMN IF NOT EXISTS distance_value NUMERIC,
+    ADD COLUMN IF NOT EXISTS expedited_shipping BOOLEAN,
+    ADD COLUMN IF NOT EXISTS handling_time INT,
+    ADD COLUMN IF NOT EXISTS one_day_shipping_available BOOLEAN,
+    ADD COLUMN IF NOT EXISTS payment_methods TEXT[],
+    ADD COLUMN IF NOT EXISTS selling_status_bid_count INT,
+    ADD COLUMN IF NOT EXISTS title_normalized TEXT,
+    ADD COLUMN IF NOT EXISTS total_cost NUMERIC;
+
+UPDATE item SET ingested_at = timestamp WHERE ingested_at IS NULL;
+
+ALTER TABLE item
+    ALTER COLUMN ingested_at SET NOT NULL,
+    ALTER COLUMN request_params DROP DEFAULT,
+    ALTER COLUMN product_id_value TYPE TEXT;
+
+COMMIT;
@@ -0,0 +1,39 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// A runSummary describes the outcome of a run for human operators.
+type runSummary struct {
+	operation  string
+	query      string
+	calls      int
+	fetched    int
+	duplicates int // items returned more than once during the run
+	inserted   int
+	updated    int // stored items updated by -upsert
+	skipped    int
+	elapsed    time.Duration
+}
+
+// printSummary writes s to w as an aligned table.
+func printSummary(w io.Writer, s runSummary) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "operation\t%s\n", s.operation)
+	fmt.Fprintf(tw, "query\t%s\n", s.query)
+	fmt.Fprintf(tw, "calls\t%d\n", s.calls)
+	fmt.Fprintf(tw, "fetched\t%d\n", s.fetched)
+	fmt.Fprintf(tw, "duplicates\t%d\n", s.duplicates)
+	fmt.Fprintf(tw, "inserted\t%d\n", s.inserted)
+	fmt.Fprintf(tw, "updated\t%d\n", s.updated)
+	fmt.Fprintf(tw, "skipped\t%d\n", s.skipped)
+	fmt.Fprintf(tw, "elapsed\t%s\n", s.elapsed.Round(time.Millisecond))
+	tw.Flush()
+}
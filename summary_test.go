@@ -0,0 +1,39 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPrintSummary(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	printSummary(&buf, runSummary{
+		operation:  "keyword",
+		query:      "phone",
+		calls:      4,
+		fetched:    300,
+		duplicates: 2,
+		inserted:   290,
+		updated:    5,
+		skipped:    3,
+		elapsed:    1500 * time.Millisecond,
+	})
+	want := `operation   keyword
+query       phone
+calls       4
+fetched     300
+duplicates  2
+inserted    290
+updated     5
+skipped     3
+elapsed     1.5s
+`
+	if got := buf.String(); got != want {
+		t.Errorf("summary =\n%s\nwant\n%s", got, want)
+	}
+}
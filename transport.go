@@ -0,0 +1,217 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errNonJSONResponse is returned when eBay responds successfully with a body
+// that is not JSON, such as the HTML page served during maintenance.
+var errNonJSONResponse = errors.New("eBay returned a non-JSON response")
+
+// A transport is an [http.RoundTripper] for eBay Finding API requests. It
+// rejects non-JSON responses before they reach the JSON decoder, retries
+// transient failures with exponential backoff, or after the delay eBay asks
+// for when it throttles requests, and records the deprecation notices eBay
+// sends in response headers.
+type transport struct {
+	base http.RoundTripper
+
+	// maxAttempts is the most times a request is attempted, unless the
+	// request context carries a call with its own limit.
+	maxAttempts int
+
+	// baseDelay is the delay before the first retry, which doubles with
+	// each further retry.
+	baseDelay time.Duration
+
+	// maxRetryAfter caps the delay honored from a Retry-After header.
+	maxRetryAfter time.Duration
+
+	// audit reports whether response bodies are checked for fields the
+	// ebay package does not model.
+	audit bool
+
+	mu sync.Mutex
+
+	// calls is the number of HTTP requests sent, retries included.
+	calls int
+
+	// deprecations are the deprecation notices in the X-EBAY-SOA-*
+	// headers of the responses received.
+	deprecations []string
+}
+
+// A call holds the state of one eBay request that is shared between
+// findPage and the transport through the request context.
+type call struct {
+	// attempts is the number of times the request was sent, counting the
+	// retries of both the transport and findPage, and limit is the most
+	// times it may be sent.
+	attempts, limit int
+
+	// err is the last error the transport returned. The ebay package keeps
+	// only its text, so findPage restores it from here.
+	err error
+}
+
+type callKey struct{}
+
+// withCall returns a copy of ctx carrying cl to the transport.
+func withCall(ctx context.Context, cl *call) context.Context {
+	return context.WithValue(ctx, callKey{}, cl)
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cl, ok := req.Context().Value(callKey{}).(*call)
+	if !ok {
+		cl = &call{limit: t.maxAttempts}
+	}
+	resp, err := t.retry(req, cl)
+	if err != nil {
+		cl.err = err
+	}
+	return resp, err
+}
+
+// retry sends req until it succeeds, fails in a way that retrying cannot
+// fix, or has been sent cl.limit times.
+func (t *transport) retry(req *http.Request, cl *call) (*http.Response, error) {
+	ctx := req.Context()
+	for {
+		t.mu.Lock()
+		t.calls++
+		t.mu.Unlock()
+		cl.attempts++
+		resp, err := t.roundTrip(req)
+		if cl.attempts >= cl.limit || ctx.Err() != nil || !retryable(resp, err) {
+			return resp, err
+		}
+		delay := backoff(t.baseDelay, cl.attempts)
+		if resp != nil {
+			if resp.StatusCode == http.StatusTooManyRequests {
+				if d, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+					delay = min(d, t.maxRetryAfter)
+				}
+			}
+			resp.Body.Close()
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleep waits for d, or until ctx is done and returns its error. It may be
+// replaced to observe delays without waiting.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryable reports whether a request that ended with resp and err may
+// succeed if retried.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// backoff returns the delay before retrying after the given attempt: base
+// doubled for each attempt after the first, with up to half of it jittered.
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := base << (attempt - 1)
+	if d < 2 {
+		return d
+	}
+	return d/2 + rand.N(d/2)
+}
+
+// retryAfter returns the delay requested by the Retry-After header value v,
+// given in seconds or as an HTTP date relative to now.
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		if n < 0 {
+			return 0, false
+		}
+		return time.Duration(n) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
+
+// noteDeprecations records the X-EBAY-SOA-* headers in h that are
+// deprecation notices, named as such or mentioning deprecation.
+func (t *transport) noteDeprecations(h http.Header) {
+	for k, vs := range h {
+		if !strings.HasPrefix(strings.ToUpper(k), "X-EBAY-SOA-") {
+			continue
+		}
+		for _, v := range vs {
+			if isDeprecation(k) || isDeprecation(v) {
+				t.mu.Lock()
+				t.deprecations = append(t.deprecations, v)
+				t.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (t *transport) roundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.noteDeprecations(resp.Header)
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	br := bufio.NewReader(resp.Body)
+	b, _ := br.Peek(512)
+	ct := resp.Header.Get("Content-Type")
+	if strings.Contains(ct, "html") || bytes.HasPrefix(bytes.TrimSpace(b), []byte("<")) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: content type %q", errNonJSONResponse, ct)
+	}
+	var r io.Reader = br
+	if t.audit {
+		body, err := io.ReadAll(br)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		auditFields(body)
+		r = bytes.NewReader(body)
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{r, resp.Body}
+	return resp, nil
+}
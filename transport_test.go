@@ -0,0 +1,277 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matthewdargan/ebay"
+)
+
+// newTestClient returns a client for the eBay Finding API served by h, with
+// the transport it uses.
+func newTestClient(t *testing.T, h http.HandlerFunc) (*ebay.FindingClient, *transport) {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	tr := &transport{base: http.DefaultTransport, maxAttempts: 3, baseDelay: time.Millisecond, maxRetryAfter: time.Second}
+	c, err := newFindingClient(tr, "app", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, tr
+}
+
+// writePage writes the findItemsByKeywords response for page of totalPages
+// holding one item.
+func writePage(w http.ResponseWriter, page, totalPages int) {
+	json.NewEncoder(w).Encode(ebay.FindItemsByKeywordsResponse{
+		ItemsResponse: []ebay.FindItemsResponse{testPage(page, totalPages, testItem(strconv.Itoa(page)))},
+	})
+}
+
+//nolint:paralleltest // Replaces sleep.
+func TestTransportCalls(t *testing.T) {
+	stubSleep(t)
+	retried := false
+	c, tr := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("paginationInput.pageNumber"))
+		if page == 2 && !retried {
+			retried = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writePage(w, page, 3)
+	})
+	rs, _, err := findAll(context.Background(), c, operations["keyword"], map[string]string{"keywords": "calls"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 3 {
+		t.Errorf("fetched %d pages, want 3", len(rs))
+	}
+	if tr.calls != 4 {
+		t.Errorf("calls = %d, want 4", tr.calls)
+	}
+}
+
+func TestTransportDeprecations(t *testing.T) {
+	t.Parallel()
+	c, tr := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-EBAY-SOA-DEPRECATION", "findItemsByKeywords will be retired")
+		w.Header().Set("X-EBAY-SOA-WARNING", "this version is deprecated")
+		w.Header().Set("X-EBAY-SOA-SERVICE-VERSION", "1.13.0")
+		w.Write([]byte(`{"findItemsByKeywordsResponse":[{"ack":["Success"]}]}`))
+	})
+	if _, err := c.FindItemsByKeywords(context.Background(), map[string]string{"keywords": "phone"}); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(tr.deprecations)
+	want := []string{"findItemsByKeywords will be retired", "this version is deprecated"}
+	if !slices.Equal(tr.deprecations, want) {
+		t.Errorf("deprecations = %q, want %q", tr.deprecations, want)
+	}
+}
+
+func TestTransportNonJSON(t *testing.T) {
+	t.Parallel()
+	c, tr := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>eBay is down for maintenance</body></html>"))
+	})
+	_, err := findPage(context.Background(), c, operations["keyword"], map[string]string{"keywords": "maintenance"})
+	if !errors.Is(err, errNonJSONResponse) {
+		t.Errorf("findPage = %v, want %v", err, errNonJSONResponse)
+	}
+	if !errors.Is(err, ebay.ErrFailedRequest) {
+		t.Errorf("findPage = %v, want %v", err, ebay.ErrFailedRequest)
+	}
+	if tr.calls != tr.maxAttempts {
+		t.Errorf("calls = %d, want %d retries of the non-JSON response", tr.calls, tr.maxAttempts)
+	}
+}
+
+//nolint:paralleltest // Replaces sleep.
+func TestTransportRetry(t *testing.T) {
+	delays := stubSleep(t)
+	failures := 2
+	c, tr := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writePage(w, 1, 1)
+	})
+	r, err := c.FindItemsByKeywords(context.Background(), map[string]string{"keywords": "retry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.ItemsResponse) != 1 {
+		t.Errorf("got %d responses, want 1", len(r.ItemsResponse))
+	}
+	if tr.calls != 3 || len(*delays) != 2 {
+		t.Errorf("calls = %d with %d delays, want 3 with 2", tr.calls, len(*delays))
+	}
+}
+
+//nolint:paralleltest // Replaces sleep.
+func TestTransportNoRetry(t *testing.T) {
+	stubSleep(t)
+	c, tr := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	_, err := c.FindItemsByKeywords(context.Background(), map[string]string{"keywords": "no retry"})
+	if !errors.Is(err, ebay.ErrInvalidStatus) {
+		t.Errorf("FindItemsByKeywords = %v, want %v", err, ebay.ErrInvalidStatus)
+	}
+	if tr.calls != 1 {
+		t.Errorf("calls = %d, want 1", tr.calls)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+	for attempt, limit := range []time.Duration{100, 200, 400} {
+		if d := backoff(100, attempt+1); d < limit/2 || d >= limit {
+			t.Errorf("backoff(100, %d) = %d, want in [%d, %d)", attempt+1, d, limit/2, limit)
+		}
+	}
+}
+
+//nolint:paralleltest // Replaces sleep.
+func TestTransportRetryAfter(t *testing.T) {
+	tests := []struct {
+		retryAfter string
+		want       time.Duration
+	}{
+		{"2", 2 * time.Second},
+		{"30", 5 * time.Second}, // capped by maxRetryAfter
+	}
+	for _, tt := range tests {
+		delays := stubSleep(t)
+		throttled := false
+		c, tr := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if !throttled {
+				throttled = true
+				w.Header().Set("Retry-After", tt.retryAfter)
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			writePage(w, 1, 1)
+		})
+		tr.maxRetryAfter = 5 * time.Second
+		if _, err := c.FindItemsByKeywords(context.Background(), map[string]string{"keywords": "throttled"}); err != nil {
+			t.Fatal(err)
+		}
+		if want := []time.Duration{tt.want}; !slices.Equal(*delays, want) {
+			t.Errorf("Retry-After %s: delays = %v, want %v", tt.retryAfter, *delays, want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		v      string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"2", 2 * time.Second, true},
+		{"0", 0, true},
+		{now.Add(3 * time.Second).Format(http.TimeFormat), 3 * time.Second, true},
+		{now.Add(-time.Second).Format(http.TimeFormat), 0, true},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		if d, ok := retryAfter(tt.v, now); d != tt.want || ok != tt.wantOK {
+			t.Errorf("retryAfter(%q) = %v, %t, want %v, %t", tt.v, d, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+//nolint:paralleltest // Replaces sleep and captures the log.
+func TestTransportAttemptBudget(t *testing.T) {
+	stubSleep(t)
+	captureLog(t)
+	var n int
+	c, tr := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		n++
+		if n%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r1 := testPage(1, 1)
+		r1.ErrorMessage = []ebay.ErrorMessage{{Error: []ebay.ErrorData{{
+			Category: []string{"System"},
+			Severity: []string{"Error"},
+			Message:  []string{"Internal error."},
+		}}}}
+		json.NewEncoder(w).Encode(ebay.FindItemsByKeywordsResponse{ItemsResponse: []ebay.FindItemsResponse{r1}})
+	})
+	_, err := findPage(context.Background(), c, operations["keyword"], map[string]string{"keywords": "budget"})
+	if !errors.Is(err, ebay.ErrInvalidStatus) {
+		t.Errorf("findPage = %v, want %v", err, ebay.ErrInvalidStatus)
+	}
+	if tr.calls != *maxAttempts {
+		t.Errorf("calls = %d, want %d", tr.calls, *maxAttempts)
+	}
+}
+
+func TestFindingClientURL(t *testing.T) {
+	t.Parallel()
+	var got *url.URL
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL
+		writePage(w, 1, 1)
+	})
+	if _, err := c.FindItemsByKeywords(context.Background(), map[string]string{"keywords": "endpoint"}); err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Query().Get("keywords") != "endpoint" || got.Query().Get("Operation-Name") != "findItemsByKeywords" {
+		t.Errorf("-url endpoint received %v, want the findItemsByKeywords request", got)
+	}
+	prod, err := newFindingClient(http.DefaultTransport, "app", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://svcs.ebay.com/services/search/FindingService/v1"; prod.URL != want {
+		t.Errorf("default endpoint = %s, want %s", prod.URL, want)
+	}
+}
+
+func TestFindingClientGatewayURL(t *testing.T) {
+	t.Parallel()
+	var got *url.URL
+	c, tr := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL
+		writePage(w, 1, 1)
+	})
+	gw, err := newFindingClient(tr, "app", c.URL+"/ebay/finding?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = gw.FindItemsByKeywords(context.Background(), map[string]string{"keywords": "gateway"}); err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Path != "/ebay/finding" || got.Query().Get("x") != "1" || got.Query().Get("keywords") != "gateway" {
+		t.Errorf("gateway received %v, want /ebay/finding with x=1 and keywords=gateway", got)
+	}
+	for _, endpoint := range []string{"/ebay/finding", "proxy.internal/ebay/finding", "://"} {
+		if _, err = newFindingClient(tr, "app", endpoint); err == nil {
+			t.Errorf("newFindingClient(%q) succeeded, want error", endpoint)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errMissingBuyerPostalCode = errors.New("sortOrder DistanceNearest requires buyerPostalCode")
+	errTooManyFilterValues    = errors.New("too many item filter values")
+	errMissingProductID       = errors.New("product search requires productId and productId.@type")
+	errFilterNotAllowed       = errors.New("item filter not allowed for operation")
+	errMissingStoreQuery      = errors.New("store search requires storeName, categoryId, or keywords")
+	errUnknownOutputSelector  = errors.New("unknown outputSelector")
+	errInvalidSortOrder       = errors.New("invalid sortOrder")
+	errInvalidEntriesPerPage  = errors.New("paginationInput.entriesPerPage must be an integer from 1 to 100")
+	errInvalidPageNumber      = errors.New("paginationInput.pageNumber must be an integer from 1 to 100")
+	errIncompleteAspectFilter = errors.New("aspect filter requires aspectName and aspectValueName")
+	errKeywordsTooLong        = errors.New("keywords longer than 350 bytes")
+	errInvalidTimeRange       = errors.New("time range start is not before its end")
+	errModTimeTooOld          = errors.New("ModTimeFrom is more than 120 days ago")
+	errInvalidBooleanValue    = errors.New("item filter value must be true or false")
+	errInvalidCategoryID      = errors.New("ExcludeCategory value must be a non-negative integer")
+	errInvalidCharityID       = errors.New("charityId must be a positive integer")
+)
+
+// searchItemFilters lists the item filters accepted by searches other than
+// product searches.
+var searchItemFilters = []string{
+	"AuthorizedSellerOnly", "AvailableTo", "BestOfferOnly", "CharityOnly",
+	"Condition", "Currency", "EndTimeFrom", "EndTimeTo", "ExcludeAutoPay",
+	"ExcludeCategory", "ExcludeSeller", "ExpeditedShippingType",
+	"FeaturedOnly", "FeedbackScoreMax", "FeedbackScoreMin",
+	"FreeShippingOnly", "GetItFastOnly", "HideDuplicateItems", "ListedIn",
+	"ListingType", "LocalPickupOnly", "LocalSearchOnly", "LocatedIn",
+	"LotsOnly", "MaxBids", "MaxDistance", "MaxHandlingTime", "MaxPrice",
+	"MaxQuantity", "MinBids", "MinPrice", "MinQuantity", "ModTimeFrom",
+	"OutletSellerOnly", "PaymentMethod", "ReturnsAcceptedOnly", "Seller",
+	"SellerBusinessType", "StartTimeFrom", "StartTimeTo",
+	"TopRatedSellerOnly", "ValueBoxInventory", "WorldOfGoodOnly",
+}
+
+// booleanItemFilters are the item filters whose value is true or false.
+var booleanItemFilters = []string{
+	"AuthorizedSellerOnly", "BestOfferOnly", "CharityOnly", "ExcludeAutoPay",
+	"FeaturedOnly", "FreeShippingOnly", "GetItFastOnly", "HideDuplicateItems",
+	"LocalPickupOnly", "LocalSearchOnly", "LotsOnly", "OutletSellerOnly",
+	"ReturnsAcceptedOnly", "TopRatedSellerOnly", "WorldOfGoodOnly",
+}
+
+// operationItemFilters maps operation names to the item filters they
+// accept. Product searches match a single catalog product, so category
+// exclusion and local search do not apply.
+var operationItemFilters = map[string][]string{
+	"advanced":   searchItemFilters,
+	"category":   searchItemFilters,
+	"keyword":    searchItemFilters,
+	"ebay-store": searchItemFilters,
+	"product": slices.DeleteFunc(slices.Clone(searchItemFilters), func(f string) bool {
+		return f == "ExcludeCategory" || f == "LocalSearchOnly"
+	}),
+}
+
+// productIDTypes are the product ID types accepted by product searches.
+var productIDTypes = []string{"EAN", "ISBN", "ReferenceID", "UPC"}
+
+// outputSelectors are the outputSelector values eBay accepts.
+var outputSelectors = []string{
+	"AspectHistogram", "CategoryHistogram", "ConditionHistogram",
+	"GalleryInfo", "PictureURLLarge", "PictureURLSuperSize", "SellerInfo",
+	"StoreInfo", "UnitPriceInfo",
+}
+
+// sortOrders are the sortOrder values eBay accepts.
+var sortOrders = []string{
+	"BestMatch", "BidCountFewest", "BidCountMost", "CountryAscending",
+	"CountryDescending", "CurrentPriceHighest", "DistanceNearest",
+	"EndTimeSoonest", "PricePlusShippingHighest", "PricePlusShippingLowest",
+	"StartTimeNewest", "WatchCountDecreaseSort",
+}
+
+// maxKeywordsLen is the most bytes of keywords eBay accepts.
+const maxKeywordsLen = 350
+
+// now returns the current time for validating time item filters. It may be
+// replaced to validate as of a fixed time.
+var now = time.Now
+
+// maxModTimeAge is how far in the past eBay honors ModTimeFrom.
+const maxModTimeAge = 120 * 24 * time.Hour
+
+// maxPagination is the most entries per page and the highest page number
+// eBay accepts.
+const maxPagination = 100
+
+// multiValueItemFilters are the item filters that accept more than one
+// value.
+var multiValueItemFilters = []string{
+	"Condition", "ExcludeCategory", "ExcludeSeller", "ListingType",
+	"LocatedIn", "Seller",
+}
+
+// maxFilterValues maps item filter names to the most values eBay accepts for
+// them. Other filters in multiValueItemFilters are not limited.
+var maxFilterValues = map[string]int{
+	"ExcludeCategory": 25,
+	"ExcludeSeller":   100,
+	"LocatedIn":       25,
+	"Seller":          100,
+}
+
+// validateParams reports an error if params would be rejected by the eBay
+// Finding API for the operation op.
+func validateParams(op string, params map[string]string) error {
+	if operations[op].name == "product" {
+		if params["productId"] == "" || params["productId.@type"] == "" {
+			return errMissingProductID
+		}
+		if t := params["productId.@type"]; !slices.Contains(productIDTypes, t) {
+			return fmt.Errorf("invalid productId.@type %q: must be one of %s", t, strings.Join(productIDTypes, ", "))
+		}
+	}
+	if operations[op].name == "ebay-store" && params["storeName"] == "" && params["categoryId"] == "" && params["keywords"] == "" {
+		return errMissingStoreQuery
+	}
+	if n := len(strings.Join(strings.Fields(params["keywords"]), " ")); n > maxKeywordsLen {
+		return fmt.Errorf("%w: got %d", errKeywordsTooLong, n)
+	}
+	if id, ok := params["charityId"]; ok {
+		if n, err := strconv.Atoi(id); err != nil || n < 1 {
+			return fmt.Errorf("%w, got %q", errInvalidCharityID, id)
+		}
+	}
+	if o, ok := params["sortOrder"]; ok && !slices.Contains(sortOrders, o) {
+		return fmt.Errorf("%w %q: must be one of %s", errInvalidSortOrder, o, strings.Join(sortOrders, ", "))
+	}
+	for _, p := range []struct {
+		name string
+		err  error
+	}{
+		{"paginationInput.entriesPerPage", errInvalidEntriesPerPage},
+		{"paginationInput.pageNumber", errInvalidPageNumber},
+	} {
+		v, ok := params[p.name]
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(v); err != nil || n < 1 || n > maxPagination {
+			return fmt.Errorf("%w, got %q", p.err, v)
+		}
+	}
+	if params["sortOrder"] == "DistanceNearest" && params["buyerPostalCode"] == "" {
+		return errMissingBuyerPostalCode
+	}
+	for _, f := range aspectFilters(params) {
+		if f.name == "" || len(f.values) == 0 {
+			return fmt.Errorf("%w: got aspectName %q with values %q", errIncompleteAspectFilter, f.name, f.values)
+		}
+	}
+	if err := checkTimeRanges(params); err != nil {
+		return err
+	}
+	for k, v := range params {
+		if paramName(k) == "outputSelector" && !slices.Contains(outputSelectors, v) {
+			return fmt.Errorf("%w %q: must be one of %s", errUnknownOutputSelector, v, strings.Join(outputSelectors, ", "))
+		}
+	}
+	for _, f := range itemFilters(params) {
+		if !slices.Contains(operationItemFilters[operations[op].name], f.name) {
+			return fmt.Errorf("%w: %s in %s", errFilterNotAllowed, f.name, op)
+		}
+		if n, ok := maxFilterValues[f.name]; ok && len(f.values) > n {
+			return fmt.Errorf("%w: %s accepts at most %d, got %d", errTooManyFilterValues, f.name, n, len(f.values))
+		}
+		if !slices.Contains(multiValueItemFilters, f.name) && len(f.values) > 1 {
+			return fmt.Errorf("%w: %s accepts one value, got %d", errTooManyFilterValues, f.name, len(f.values))
+		}
+		if slices.Contains(booleanItemFilters, f.name) {
+			if v := first(f.values); v != "true" && v != "false" {
+				return fmt.Errorf("%w: %s is %q", errInvalidBooleanValue, f.name, v)
+			}
+		}
+		if f.name == "ExcludeCategory" {
+			for _, v := range f.values {
+				if n, err := strconv.Atoi(v); err != nil || n < 0 {
+					return fmt.Errorf("%w, got %q", errInvalidCategoryID, v)
+				}
+			}
+		}
+		if f.name == "ModTimeFrom" {
+			if t, err := time.Parse(time.RFC3339, first(f.values)); err == nil && now().Sub(t) > maxModTimeAge {
+				return fmt.Errorf("%w: got %s", errModTimeTooOld, t.Format(time.RFC3339))
+			}
+		}
+	}
+	return nil
+}
+
+// checkTimeRanges reports an error if the EndTimeFrom or StartTimeFrom item
+// filter in params is not before the matching EndTimeTo or StartTimeTo.
+func checkTimeRanges(params map[string]string) error {
+	times := make(map[string]time.Time)
+	for _, f := range itemFilters(params) {
+		if t, err := time.Parse(time.RFC3339, first(f.values)); err == nil {
+			times[f.name] = t
+		}
+	}
+	for _, r := range [][2]string{{"EndTimeFrom", "EndTimeTo"}, {"StartTimeFrom", "StartTimeTo"}} {
+		from, fromOK := times[r[0]]
+		to, toOK := times[r[1]]
+		if fromOK && toOK && !from.Before(to) {
+			return fmt.Errorf("%w: %s %s, %s %s", errInvalidTimeRange, r[0], from.Format(time.RFC3339), r[1], to.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// isItemFilterName reports whether k names an item filter, as in
+// itemFilter.name or itemFilter(0).name.
+func isItemFilterName(k string) bool {
+	return strings.HasPrefix(k, "itemFilter") && strings.HasSuffix(k, ".name")
+}
+
+var errImplausibleCategoryID = errors.New("ExcludeCategory value is not a category ID")
+
+// checkExcludeCategories reports an error if an ExcludeCategory item filter
+// in params excludes category 0, which no category has.
+func checkExcludeCategories(params map[string]string) error {
+	for _, f := range itemFilters(params) {
+		if f.name != "ExcludeCategory" {
+			continue
+		}
+		for _, v := range f.values {
+			if n, err := strconv.Atoi(v); err == nil && n == 0 {
+				return fmt.Errorf("%w: %s", errImplausibleCategoryID, v)
+			}
+		}
+	}
+	return nil
+}
+
+// siteCurrencies maps eBay global IDs to the currency of the site.
+var siteCurrencies = map[string]string{
+	"EBAY-AT":    "EUR",
+	"EBAY-AU":    "AUD",
+	"EBAY-CH":    "CHF",
+	"EBAY-DE":    "EUR",
+	"EBAY-ENCA":  "CAD",
+	"EBAY-ES":    "EUR",
+	"EBAY-FR":    "EUR",
+	"EBAY-FRBE":  "EUR",
+	"EBAY-FRCA":  "CAD",
+	"EBAY-GB":    "GBP",
+	"EBAY-HK":    "HKD",
+	"EBAY-IE":    "EUR",
+	"EBAY-IN":    "INR",
+	"EBAY-IT":    "EUR",
+	"EBAY-MOTOR": "USD",
+	"EBAY-MY":    "MYR",
+	"EBAY-NL":    "EUR",
+	"EBAY-NLBE":  "EUR",
+	"EBAY-PH":    "PHP",
+	"EBAY-PL":    "PLN",
+	"EBAY-SG":    "SGD",
+	"EBAY-US":    "USD",
+}
+
+var errCurrencyMismatch = errors.New("item filter currency does not match site currency")
+
+// checkSiteCurrency reports an error if a MaxPrice or MinPrice item filter
+// in params uses a currency, or a Currency item filter names a currency,
+// other than that of the site given by GLOBAL-ID, which defaults to EBAY-US.
+func checkSiteCurrency(params map[string]string) error {
+	site := cmp.Or(params["GLOBAL-ID"], "EBAY-US")
+	want, ok := siteCurrencies[site]
+	if !ok {
+		return nil
+	}
+	for _, f := range itemFilters(params) {
+		switch {
+		case f.name == "Currency":
+			if v := first(f.values); v != want {
+				return fmt.Errorf("%w: Currency filter is %s, %s uses %s", errCurrencyMismatch, v, site, want)
+			}
+		case (f.name == "MaxPrice" || f.name == "MinPrice") && f.paramName == "Currency":
+			if f.paramValue != want {
+				return fmt.Errorf("%w: %s uses %s, %s uses %s", errCurrencyMismatch, f.name, f.paramValue, site, want)
+			}
+		}
+	}
+	return nil
+}
+
+// sitePostalCodes maps eBay global IDs to the format of postal codes in the
+// site's country. Sites whose country has no postal codes are not listed.
+var sitePostalCodes = map[string]*regexp.Regexp{
+	"EBAY-AT":    regexp.MustCompile(`^\d{4}$`),
+	"EBAY-AU":    regexp.MustCompile(`^\d{4}$`),
+	"EBAY-CH":    regexp.MustCompile(`^\d{4}$`),
+	"EBAY-DE":    regexp.MustCompile(`^\d{5}$`),
+	"EBAY-ENCA":  regexp.MustCompile(`(?i)^[A-Z]\d[A-Z] ?\d[A-Z]\d$`),
+	"EBAY-ES":    regexp.MustCompile(`^\d{5}$`),
+	"EBAY-FR":    regexp.MustCompile(`^\d{5}$`),
+	"EBAY-FRBE":  regexp.MustCompile(`^\d{4}$`),
+	"EBAY-FRCA":  regexp.MustCompile(`(?i)^[A-Z]\d[A-Z] ?\d[A-Z]\d$`),
+	"EBAY-GB":    regexp.MustCompile(`(?i)^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`),
+	"EBAY-IE":    regexp.MustCompile(`(?i)^[A-Z]\d[\dW] ?[A-Z\d]{4}$`),
+	"EBAY-IN":    regexp.MustCompile(`^\d{6}$`),
+	"EBAY-IT":    regexp.MustCompile(`^\d{5}$`),
+	"EBAY-MOTOR": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+	"EBAY-MY":    regexp.MustCompile(`^\d{5}$`),
+	"EBAY-NL":    regexp.MustCompile(`(?i)^\d{4} ?[A-Z]{2}$`),
+	"EBAY-NLBE":  regexp.MustCompile(`^\d{4}$`),
+	"EBAY-PH":    regexp.MustCompile(`^\d{4}$`),
+	"EBAY-PL":    regexp.MustCompile(`^\d{2}-\d{3}$`),
+	"EBAY-SG":    regexp.MustCompile(`^\d{6}$`),
+	"EBAY-US":    regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+}
+
+var errPostalCodeMismatch = errors.New("buyerPostalCode does not match site postal code format")
+
+// checkSitePostalCode reports an error if the buyerPostalCode in params is
+// not in the postal code format of the site given by GLOBAL-ID, which
+// defaults to EBAY-US.
+func checkSitePostalCode(params map[string]string) error {
+	code, ok := params["buyerPostalCode"]
+	if !ok {
+		return nil
+	}
+	site := cmp.Or(params["GLOBAL-ID"], "EBAY-US")
+	re, ok := sitePostalCodes[site]
+	if !ok || re.MatchString(code) {
+		return nil
+	}
+	return fmt.Errorf("%w: %q for %s", errPostalCodeMismatch, code, site)
+}
+
+// siteGlobalIDs maps numeric eBay site IDs to global IDs.
+var siteGlobalIDs = map[string]string{
+	"0":   "EBAY-US",
+	"2":   "EBAY-ENCA",
+	"3":   "EBAY-GB",
+	"15":  "EBAY-AU",
+	"16":  "EBAY-AT",
+	"23":  "EBAY-FRBE",
+	"71":  "EBAY-FR",
+	"77":  "EBAY-DE",
+	"100": "EBAY-MOTOR",
+	"101": "EBAY-IT",
+	"123": "EBAY-NLBE",
+	"146": "EBAY-NL",
+	"186": "EBAY-ES",
+	"193": "EBAY-CH",
+	"201": "EBAY-HK",
+	"203": "EBAY-IN",
+	"205": "EBAY-IE",
+	"207": "EBAY-MY",
+	"210": "EBAY-FRCA",
+	"211": "EBAY-PH",
+	"212": "EBAY-PL",
+	"216": "EBAY-SG",
+}
+
+// applySiteID replaces a numeric siteId in params with the GLOBAL-ID the
+// eBay Finding API expects.
+func applySiteID(params map[string]string) error {
+	id, ok := params["siteId"]
+	if !ok {
+		return nil
+	}
+	globalID, ok := siteGlobalIDs[id]
+	if !ok {
+		return fmt.Errorf("unknown siteId %q", id)
+	}
+	if g := params["GLOBAL-ID"]; g != "" && g != globalID {
+		return fmt.Errorf("siteId %s (%s) conflicts with GLOBAL-ID %s", id, globalID, g)
+	}
+	delete(params, "siteId")
+	params["GLOBAL-ID"] = globalID
+	return nil
+}
@@ -0,0 +1,300 @@
+// Copyright 2024 Matthew P. Dargan.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateDistanceSort(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		op, params string
+		want       error
+	}{
+		{"category", "categoryId=9355&buyerPostalCode=10001&sortOrder=DistanceNearest", nil},
+		{"category", "categoryId=9355&sortOrder=DistanceNearest", errMissingBuyerPostalCode},
+		{"keyword", "keywords=phone&sortOrder=DistanceNearest", errMissingBuyerPostalCode},
+	}
+	for _, tt := range tests {
+		if _, err := loadParams(tt.op, tt.params); !errors.Is(err, tt.want) {
+			t.Errorf("loadParams(%q, %q) = %v, want %v", tt.op, tt.params, err, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		op, params string
+		want       error
+	}{
+		{"keyword", "keywords=phone&itemFilter(0).name=MaxPrice&itemFilter(0).value=10&itemFilter(1).name=LotsOnly&itemFilter(1).value=false", nil},
+		{"keyword", "keywords=phone&itemFilter.name=LotsOnly&itemFilter.value=123", errInvalidBooleanValue},
+		{"product", "productId.@type=ISBN&productId=9780131103627&itemFilter.name=ExcludeCategory&itemFilter.value=1", errFilterNotAllowed},
+		{"keyword", "keywords=phone&aspectFilter(0).aspectName=Brand&aspectFilter(0).aspectValueName=Apple&aspectFilter(1).aspectName=Color&aspectFilter(1).aspectValueName(0)=Black&aspectFilter(1).aspectValueName(1)=White", nil},
+		{"keyword", "keywords=phone&aspectFilter(0).aspectName=Brand&aspectFilter(0).aspectValueName=Apple&aspectFilter(1).aspectName=Color&aspectFilter(1).aspectValueName=Black&aspectFilter(2).aspectName=Storage", errIncompleteAspectFilter},
+		{"keyword", "keywords=phone&aspectFilter.aspectValueName=Apple", errIncompleteAspectFilter},
+		{"keyword", "keywords=" + strings.Repeat("a", maxKeywordsLen), nil},
+		{"keyword", "keywords=" + strings.Repeat("a", maxKeywordsLen+1), errKeywordsTooLong},
+		{"keyword", "keywords=" + strings.Repeat("a", 174) + "    " + strings.Repeat("b", 175), nil},
+		{"keyword", "keywords=phone&itemFilter(0).name=EndTimeFrom&itemFilter(0).value=2030-01-01T00:00:00Z&itemFilter(1).name=EndTimeTo&itemFilter(1).value=2030-01-02T00:00:00Z", nil},
+		{"keyword", "keywords=phone&itemFilter(0).name=EndTimeFrom&itemFilter(0).value=2030-01-02T00:00:00Z&itemFilter(1).name=EndTimeTo&itemFilter(1).value=2030-01-01T00:00:00Z", errInvalidTimeRange},
+		{"keyword", "keywords=phone&itemFilter(0).name=StartTimeFrom&itemFilter(0).value=2030-01-01T00:00:00Z&itemFilter(1).name=StartTimeTo&itemFilter(1).value=2030-01-01T00:00:00Z", errInvalidTimeRange},
+		{"keyword", "keywords=phone&sortOrder=EndTimeSoonest", nil},
+		{"keyword", "keywords=phone&sortOrder=Cheapest", errInvalidSortOrder},
+		{"keyword", "keywords=phone&itemFilter.name=ExcludeCategory&itemFilter.value=9355,15032", nil},
+		{"keyword", "keywords=phone&itemFilter.name=ExcludeCategory&itemFilter.value=-1", errInvalidCategoryID},
+		{"keyword", "keywords=phone&itemFilter.name=ExcludeCategory&itemFilter.value=9355,phones", errInvalidCategoryID},
+		{"keyword", "keywords=phone&charityId=10484", nil},
+		{"keyword", "keywords=phone&charityId=0", errInvalidCharityID},
+		{"keyword", "keywords=phone&charityId=unicef", errInvalidCharityID},
+		{"ebay-store", "storeName=Best Buy", nil},
+		{"ebay-store", "categoryId=9355", nil},
+		{"ebay-store", "outputSelector=StoreInfo", errMissingStoreQuery},
+		{"keyword", "keywords=phone&outputSelector(0)=SellerInfo&outputSelector(1)=PictureURLLarge", nil},
+		{"keyword", "keywords=phone&outputSelector=SellerDetails", errUnknownOutputSelector},
+		{"keyword", "keywords=phone&outputSelector(0)=SellerInfo&outputSelector(1)=Pictures", errUnknownOutputSelector},
+		{"keyword", "keywords=phone&itemFilter.name=WorldOfGoodOnly&itemFilter.value=true", nil},
+		{"keyword", "keywords=phone&itemFilter.name=WorldOfGoodOnly&itemFilter.value=false", nil},
+		{"keyword", "keywords=phone&itemFilter.name=WorldOfGoodOnly&itemFilter.value=123", errInvalidBooleanValue},
+	}
+	for _, tt := range tests {
+		if _, err := loadParams(tt.op, tt.params); !errors.Is(err, tt.want) {
+			t.Errorf("loadParams(%q, %q) = %v, want %v", tt.op, tt.params, err, tt.want)
+		}
+	}
+}
+
+func TestValidatePagination(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"0", false},
+		{"1", true},
+		{"100", true},
+		{"101", false},
+		{"ten", false},
+	}
+	for _, p := range []struct {
+		name string
+		err  error
+	}{
+		{"paginationInput.entriesPerPage", errInvalidEntriesPerPage},
+		{"paginationInput.pageNumber", errInvalidPageNumber},
+	} {
+		for _, tt := range tests {
+			var want error
+			if !tt.ok {
+				want = p.err
+			}
+			params := map[string]string{"keywords": "phone", p.name: tt.value}
+			if err := validateParams("keyword", params); !errors.Is(err, want) {
+				t.Errorf("validateParams with %s=%s = %v, want %v", p.name, tt.value, err, want)
+			}
+		}
+	}
+}
+
+// pinNow makes now return ts until the test ends.
+func pinNow(t *testing.T, ts time.Time) {
+	t.Helper()
+	old := now
+	t.Cleanup(func() { now = old })
+	now = func() time.Time { return ts }
+}
+
+//nolint:paralleltest // Pins now.
+func TestValidateModTimeFrom(t *testing.T) {
+	ts := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	pinNow(t, ts)
+	tests := []struct {
+		days int
+		want error
+	}{
+		{119, nil},
+		{121, errModTimeTooOld},
+	}
+	for _, tt := range tests {
+		from := ts.AddDate(0, 0, -tt.days).Format(time.RFC3339)
+		params := map[string]string{
+			"keywords":         "phone",
+			"itemFilter.name":  "ModTimeFrom",
+			"itemFilter.value": from,
+		}
+		if err := validateParams("keyword", params); !errors.Is(err, tt.want) {
+			t.Errorf("validateParams with ModTimeFrom %d days ago = %v, want %v", tt.days, err, tt.want)
+		}
+	}
+}
+
+func TestExpandItemFilterValues(t *testing.T) {
+	t.Parallel()
+	comma, err := loadParams("keyword", "keywords=phone&itemFilter.name=ExcludeCategory&itemFilter.value=1,2,3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	numbered, err := loadParams("keyword", "keywords=phone&itemFilter.name=ExcludeCategory&itemFilter.value(0)=1&itemFilter.value(1)=2&itemFilter.value(2)=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(comma, numbered) {
+		t.Errorf("comma-separated values = %v, want %v", comma, numbered)
+	}
+	tests := []struct {
+		params string
+		value  string
+		want   error
+	}{
+		{"keywords=phone&itemFilter.name=MaxPrice&itemFilter.value=1,000", "1,000", nil},
+		{"keywords=phone&itemFilter.name=MaxDistance&itemFilter.value=10,20", "10,20", nil},
+		{"keywords=phone&itemFilter.name=MaxPrice&itemFilter.value(0)=1&itemFilter.value(1)=000", "", errTooManyFilterValues},
+	}
+	for _, tt := range tests {
+		params, err := loadParams("keyword", tt.params)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("loadParams(%q) = %v, want %v", tt.params, err, tt.want)
+			continue
+		}
+		if err == nil && params["itemFilter.value"] != tt.value {
+			t.Errorf("loadParams(%q) value = %q, want %q", tt.params, params["itemFilter.value"], tt.value)
+		}
+	}
+	params, err := loadParams("keyword", "keywords=phone&itemFilter.name=MaxDistance&itemFilter.value=10,20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = applyDistanceUnit(params, "km"); err == nil {
+		t.Errorf("applyDistanceUnit(km) with MaxDistance 10,20 succeeded, want error")
+	}
+}
+
+func TestApplySiteID(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		params map[string]string
+		want   string
+		ok     bool
+	}{
+		{map[string]string{"siteId": "0"}, "EBAY-US", true},
+		{map[string]string{"siteId": "3"}, "EBAY-GB", true},
+		{map[string]string{"siteId": "3", "GLOBAL-ID": "EBAY-GB"}, "EBAY-GB", true},
+		{map[string]string{"GLOBAL-ID": "EBAY-DE"}, "EBAY-DE", true},
+		{map[string]string{"siteId": "999"}, "", false},
+		{map[string]string{"siteId": "3", "GLOBAL-ID": "EBAY-US"}, "", false},
+	}
+	for _, tt := range tests {
+		params := maps.Clone(tt.params)
+		err := applySiteID(params)
+		if (err == nil) != tt.ok {
+			t.Errorf("applySiteID(%v) = %v, want ok %t", tt.params, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if _, ok := params["siteId"]; ok || params["GLOBAL-ID"] != tt.want {
+			t.Errorf("applySiteID(%v) = %v, want GLOBAL-ID %s", tt.params, params, tt.want)
+		}
+	}
+}
+
+//nolint:paralleltest // Sets -strict and captures the log.
+func TestCheckSiteCurrency(t *testing.T) {
+	const gb = "keywords=phone&GLOBAL-ID=EBAY-GB&itemFilter.name=MaxPrice&itemFilter.value=100&itemFilter.paramName=Currency&itemFilter.paramValue="
+	tests := []struct {
+		params string
+		want   error
+	}{
+		{gb + "GBP", nil},
+		{gb + "USD", errCurrencyMismatch},
+		{"keywords=phone&itemFilter.name=MinPrice&itemFilter.value=5&itemFilter.paramName=Currency&itemFilter.paramValue=USD", nil},
+		{"keywords=phone&siteId=3&itemFilter.name=MinPrice&itemFilter.value=5&itemFilter.paramName=Currency&itemFilter.paramValue=USD", errCurrencyMismatch},
+	}
+	for _, tt := range tests {
+		buf := captureLog(t)
+		setVar(t, strict, false)
+		if _, err := loadParams("keyword", tt.params); err != nil {
+			t.Errorf("loadParams(%q) = %v, want a warning only", tt.params, err)
+		}
+		if warned := buf.Len() > 0; warned != (tt.want != nil) {
+			t.Errorf("loadParams(%q) logged %q, want a warning %t", tt.params, buf.String(), tt.want != nil)
+		}
+		*strict = true
+		if _, err := loadParams("keyword", tt.params); !errors.Is(err, tt.want) {
+			t.Errorf("loadParams(%q) with -strict = %v, want %v", tt.params, err, tt.want)
+		}
+	}
+}
+
+//nolint:paralleltest // Sets -strict and captures the log.
+func TestCheckSitePostalCode(t *testing.T) {
+	tests := []struct {
+		params string
+		want   error
+	}{
+		{"keywords=phone&GLOBAL-ID=EBAY-GB&buyerPostalCode=SW1A 1AA&itemFilter.name=Currency&itemFilter.value=GBP", nil},
+		{"keywords=phone&GLOBAL-ID=EBAY-GB&buyerPostalCode=10001", errPostalCodeMismatch},
+		{"keywords=phone&GLOBAL-ID=EBAY-GB&itemFilter.name=Currency&itemFilter.value=USD", errCurrencyMismatch},
+		{"keywords=phone&buyerPostalCode=10001-1234", nil},
+		{"keywords=phone&buyerPostalCode=SW1A 1AA", errPostalCodeMismatch},
+	}
+	for _, tt := range tests {
+		buf := captureLog(t)
+		setVar(t, strict, false)
+		if _, err := loadParams("keyword", tt.params); err != nil {
+			t.Errorf("loadParams(%q) = %v, want a warning only", tt.params, err)
+		}
+		if warned := buf.Len() > 0; warned != (tt.want != nil) {
+			t.Errorf("loadParams(%q) logged %q, want a warning %t", tt.params, buf.String(), tt.want != nil)
+		}
+		*strict = true
+		if _, err := loadParams("keyword", tt.params); !errors.Is(err, tt.want) {
+			t.Errorf("loadParams(%q) with -strict = %v, want %v", tt.params, err, tt.want)
+		}
+	}
+}
+
+//nolint:paralleltest // Sets -strict and captures the log.
+func TestCheckExcludeCategories(t *testing.T) {
+	const params = "keywords=phone&itemFilter.name=ExcludeCategory&itemFilter.value=9355,0"
+	buf := captureLog(t)
+	if _, err := loadParams("keyword", params); err != nil {
+		t.Errorf("loadParams(%q) = %v, want a warning only", params, err)
+	}
+	if !strings.Contains(buf.String(), errImplausibleCategoryID.Error()) {
+		t.Errorf("loadParams(%q) logged %q, want a warning", params, buf.String())
+	}
+	setVar(t, strict, true)
+	if _, err := loadParams("keyword", params); !errors.Is(err, errImplausibleCategoryID) {
+		t.Errorf("loadParams(%q) with -strict = %v, want %v", params, err, errImplausibleCategoryID)
+	}
+}
+
+func TestCharityIDForwarded(t *testing.T) {
+	t.Parallel()
+	params, err := loadParams("keyword", "keywords=phone&charityId=10484")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query().Get("charityId")
+		writePage(w, 1, 1)
+	})
+	if _, err = findPage(context.Background(), c, operations["keyword"], params); err != nil {
+		t.Fatal(err)
+	}
+	if got != "10484" {
+		t.Errorf("eBay received charityId %q, want 10484", got)
+	}
+}